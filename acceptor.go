@@ -1,10 +1,23 @@
+//go:build linux
+// +build linux
+
 package uringnet
 
 import (
-	"fmt"
+	"hash/fnv"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/y001j/uringnet/errors"
+	socket "github.com/y001j/uringnet/sockets"
 	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
 )
 
+// acceptorEntries is the size of the SQ of the acceptor ring, only the accept and the eventfd read are in flight.
+const acceptorEntries = 8
+
 // create a factory() to be used with channel based pool
 
 func NewManyForAcceptor(addr NetAddress, size uint, sqpoll bool, num int, handler EventHandler) ([]*URingNet, error) {
@@ -27,7 +40,147 @@ func NewManyForAcceptor(addr NetAddress, size uint, sqpoll bool, num int, handle
 		} else {
 			uringArray[i].SetUring(size, &uring.IOUringParams{Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP})
 		}
-		fmt.Println("Uring instance initiated!")
 	}
 	return uringArray, nil
 }
+
+// RunManyAcceptor is like RunMany, but the rings don't accept: a dedicated acceptor ring accepts the connections
+// and hands each of them over to one of the rings, which is picked by the LB of the SocketOptions passed to NewMany.
+// A connection is handed over through the task queue of the ring, which opens it on its own goroutine.
+func (loop *Ringloop) RunManyAcceptor() error {
+	return loop.runAcceptor(false)
+}
+
+// RunManyAcceptor2 is like RunManyAcceptor, but the rings use auto buffer like RunMany2, i.e. the buffer ring.
+func (loop *Ringloop) RunManyAcceptor2() error {
+	return loop.runAcceptor(true)
+}
+
+func (loop *Ringloop) runAcceptor(autoBuffer bool) error {
+	first := loop.RingNet[0]
+	if first.isDatagram() {
+		return errors.ErrUnsupportedProtocol
+	}
+	acceptor := &URingNet{
+		Addr:     first.Addr,
+		Type:     first.Type,
+		SocketFd: loop.socketFd,
+		Handler:  first.Handler,
+		ErrorLog: first.ErrorLog,
+		ringloop: loop,
+		done:     make(chan struct{}),
+	}
+	if _, err := acceptor.SetUring(acceptorEntries, &uring.IOUringParams{Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP}); err != nil {
+		return err
+	}
+	if err := acceptor.setupWakeup(); err != nil {
+		_ = acceptor.ring.Close()
+		return os.NewSyscallError("eventfd", err)
+	}
+	if err := acceptor.ring.RegisterFiles([]int32{int32(loop.socketFd)}); err != nil {
+		acceptor.closeWakeup()
+		_ = acceptor.ring.Close()
+		return err
+	}
+	loop.acceptor = acceptor
+	for i, ringNet := range loop.RingNet {
+		atomic.StoreInt32(&ringNet.started, 1)
+		go ringNet.run(uint16(i), autoBuffer)
+	}
+	go acceptor.acceptLoop()
+	return nil
+}
+
+// acceptLoop is the running cycle of the acceptor ring, every accepted connection is handed over to a ring of the loop.
+func (ringNet *URingNet) acceptLoop() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(ringNet.done)
+	ringNet.armWakeup()
+	ringNet.EchoLoop()
+	for {
+		if atomic.LoadInt32(&ringNet.inShutdown) == 1 && ringNet.drained() {
+			break
+		}
+		cqe, err := ringNet.ring.GetCQEntry(1)
+		if err != nil {
+			continue
+		}
+		data := ringNet.ops.lookup(cqe.UserData())
+		if data == nil {
+			continue
+		}
+		state := data.state
+		if cqe.Flags()&uring.IORING_CQE_F_MORE == 0 {
+			ringNet.ops.put(data)
+		}
+		switch state {
+		case uint32(wakeup):
+			ringNet.runTasks()
+		case uint32(accepted):
+			if fd := ringNet.acceptedFd(cqe); fd >= 0 {
+				ringNet.ringloop.handOver(fd)
+			}
+		}
+	}
+	ringNet.closeWakeup()
+	_ = ringNet.ring.Close()
+}
+
+// handOver hands an accepted connection over to the ring picked by the load-balancing algorithm,
+// the connection is refused if the ring has stopped. Only SourceAddrHash needs the address of the peer,
+// the connection looks it up by itself otherwise once it is asked for.
+func (loop *Ringloop) handOver(fd int) {
+	var sa unix.Sockaddr
+	if loop.RingNet[0].lb == socket.SourceAddrHash {
+		sa, _ = unix.Getpeername(fd)
+	}
+	ringNet := loop.pick(sa)
+	atomic.AddUint32(&ringNet.pending, 1)
+	if err := ringNet.trigger(func() { ringNet.adopt(fd, sa) }); err != nil {
+		atomic.AddUint32(&ringNet.pending, ^uint32(0))
+		_ = unix.Close(fd)
+	}
+}
+
+// pick returns the ring which the connection from sa is assigned to, sa is only set for SourceAddrHash.
+func (loop *Ringloop) pick(sa unix.Sockaddr) *URingNet {
+	rings := loop.RingNet
+	switch rings[0].lb {
+	case socket.LeastConnections:
+		// the connections which are on their way to a ring count, a burst of them would go to the same ring otherwise.
+		picked, least := rings[0], ^uint32(0)
+		for _, ringNet := range rings {
+			if n := atomic.LoadUint32(&ringNet.Count) + atomic.LoadUint32(&ringNet.pending); n < least {
+				picked, least = ringNet, n
+			}
+		}
+		return picked
+	case socket.SourceAddrHash:
+		// the port is left out, it differs for every connection of a client.
+		h := fnv.New32a()
+		switch sa := sa.(type) {
+		case *unix.SockaddrInet4:
+			_, _ = h.Write(sa.Addr[:])
+		case *unix.SockaddrInet6:
+			_, _ = h.Write(sa.Addr[:])
+		case *unix.SockaddrUnix:
+			_, _ = h.Write([]byte(sa.Name))
+		}
+		return rings[h.Sum32()%uint32(len(rings))]
+	}
+	return rings[int(atomic.AddUint32(&loop.next, 1))%len(rings)]
+}
+
+// adopt opens a connection handed over by the acceptor ring.
+func (ringNet *URingNet) adopt(fd int, sa unix.Sockaddr) {
+	atomic.AddUint32(&ringNet.pending, ^uint32(0))
+	if atomic.LoadInt32(&ringNet.inShutdown) == 1 {
+		_ = unix.Close(fd)
+		return
+	}
+	c := newTCPConn(fd, ringNet)
+	c.peer = sa
+	ringNet.addConn(c)
+	ringNet.serve(c)
+}
@@ -0,0 +1,272 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/y001j/uringnet/errors"
+)
+
+// Codec splits the bytes received by a connection into frames and frames the bytes written to it. A codec is
+// attached to every stream connection of a ring by URingNet.Codec, or to one connection by Conn.SetCodec.
+//
+// OnTraffic fires once for every frame a read completes, the Reader methods of the connection only see the
+// frame then. Write, Writev, ReadFrom and AsyncWrite encode what they are given as one frame, while the
+// bytes returned by OnOpen and the files of SendFile are sent as they are.
+type Codec interface {
+	// Decode returns the complete frames at the head of buf and the number of bytes of buf they take up,
+	// the rest of buf stays buffered until more bytes arrive. The frames may refer to buf, which is only
+	// valid until the frames have been handed to OnTraffic. An error closes the connection.
+	Decode(c Conn, buf []byte) (frames [][]byte, n int, err error)
+
+	// Encode appends the frame of msg to dst, which is queued behind the bytes written so far.
+	Encode(c Conn, dst, msg []byte) ([]byte, error)
+}
+
+// decode splits the bytes buffered by the connection into frames and hands them to OnTraffic.
+func (ringNet *URingNet) decode(c *conn) {
+	frames, n, err := c.codec.Decode(c, c.buffered())
+	if err != nil {
+		c.saveLeftover()
+		ringNet.close(c, err)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		return
+	}
+	c.consume(n)
+	ringNet.traffic(c, frames)
+}
+
+// traffic fires OnTraffic for every frame. The bytes written for the frames are sent together, the action
+// of the last frame decides whether the connection keeps reading, unless a frame closes the connection or
+// shuts the loop down, the frames behind it are dropped then. More bytes are read if there is no frame.
+func (ringNet *URingNet) traffic(c *conn, frames [][]byte) {
+	action := Read
+	if len(frames) > 0 {
+		action = None
+	}
+	c.decoding = true
+	for _, frame := range frames {
+		c.frame = frame
+		next := ringNet.Handler.OnTraffic(c)
+		if next == Close || next == EchoAndClose || next == Shutdown {
+			action = next
+			break
+		}
+		send := action == Echo || action == Write || next == Echo || next == Write
+		switch read := next == Echo || next == Read; {
+		case send && read:
+			action = Echo
+		case send:
+			action = Write
+		case read:
+			action = Read
+		default:
+			action = None
+		}
+	}
+	c.decoding = false
+	c.frame = nil
+	c.saveLeftover()
+	ringNet.act(c, action)
+}
+
+// DelimiterBasedFrameCodec frames the messages with a delimiter, which is stripped from the decoded frames.
+type DelimiterBasedFrameCodec struct {
+	delimiter []byte
+}
+
+// NewDelimiterBasedFrameCodec returns a codec whose frames end with delimiter.
+func NewDelimiterBasedFrameCodec(delimiter []byte) (*DelimiterBasedFrameCodec, error) {
+	if len(delimiter) == 0 {
+		return nil, errors.ErrEmptyDelimiter
+	}
+	return &DelimiterBasedFrameCodec{delimiter: append([]byte(nil), delimiter...)}, nil
+}
+
+// Decode implements Codec.
+func (cc *DelimiterBasedFrameCodec) Decode(_ Conn, buf []byte) (frames [][]byte, n int, err error) {
+	for {
+		i := bytes.Index(buf[n:], cc.delimiter)
+		if i < 0 {
+			return frames, n, nil
+		}
+		frames = append(frames, buf[n:n+i])
+		n += i + len(cc.delimiter)
+	}
+}
+
+// Encode implements Codec.
+func (cc *DelimiterBasedFrameCodec) Encode(_ Conn, dst, msg []byte) ([]byte, error) {
+	return append(append(dst, msg...), cc.delimiter...), nil
+}
+
+// LineBasedFrameCodec frames the messages by lines, the frames are decoded without "\n" or "\r\n" and encoded
+// with "\n".
+type LineBasedFrameCodec struct{}
+
+// Decode implements Codec.
+func (cc *LineBasedFrameCodec) Decode(_ Conn, buf []byte) (frames [][]byte, n int, err error) {
+	for {
+		i := bytes.IndexByte(buf[n:], '\n')
+		if i < 0 {
+			return frames, n, nil
+		}
+		frames = append(frames, bytes.TrimSuffix(buf[n:n+i], []byte{'\r'}))
+		n += i + 1
+	}
+}
+
+// Encode implements Codec.
+func (cc *LineBasedFrameCodec) Encode(_ Conn, dst, msg []byte) ([]byte, error) {
+	return append(append(dst, msg...), '\n'), nil
+}
+
+// FixedLengthFrameCodec frames the messages by a fixed length, a message written to the connection must
+// be that long.
+type FixedLengthFrameCodec struct {
+	frameLength int
+}
+
+// NewFixedLengthFrameCodec returns a codec whose frames are frameLength bytes long.
+func NewFixedLengthFrameCodec(frameLength int) (*FixedLengthFrameCodec, error) {
+	if frameLength <= 0 {
+		return nil, errors.ErrInvalidFixedLength
+	}
+	return &FixedLengthFrameCodec{frameLength: frameLength}, nil
+}
+
+// Decode implements Codec.
+func (cc *FixedLengthFrameCodec) Decode(_ Conn, buf []byte) (frames [][]byte, n int, err error) {
+	for len(buf)-n >= cc.frameLength {
+		frames = append(frames, buf[n:n+cc.frameLength])
+		n += cc.frameLength
+	}
+	return frames, n, nil
+}
+
+// Encode implements Codec.
+func (cc *FixedLengthFrameCodec) Encode(_ Conn, dst, msg []byte) ([]byte, error) {
+	if len(msg) != cc.frameLength {
+		return dst, errors.ErrUnexpectedFrameLength
+	}
+	return append(dst, msg...), nil
+}
+
+// EncoderConfig is the configuration of the encoder of LengthFieldBasedFrameCodec.
+type EncoderConfig struct {
+	// ByteOrder is the byte order of the length field.
+	ByteOrder binary.ByteOrder
+	// LengthFieldLength is the length of the length field, it is 1, 2, 4 or 8.
+	LengthFieldLength int
+	// LengthAdjustment is added to the length of the message to get the value of the length field.
+	LengthAdjustment int
+	// LengthIncludesLengthFieldLength reports whether the value of the length field includes the length field.
+	LengthIncludesLengthFieldLength bool
+}
+
+// DecoderConfig is the configuration of the decoder of LengthFieldBasedFrameCodec.
+type DecoderConfig struct {
+	// ByteOrder is the byte order of the length field.
+	ByteOrder binary.ByteOrder
+	// LengthFieldOffset is the offset of the length field in the frame.
+	LengthFieldOffset int
+	// LengthFieldLength is the length of the length field, it is 1, 2, 4 or 8.
+	LengthFieldLength int
+	// LengthAdjustment is added to the value of the length field to get the number of bytes which follow
+	// the length field.
+	LengthAdjustment int
+	// InitialBytesToStrip is the number of bytes which are stripped from the head of a decoded frame.
+	InitialBytesToStrip int
+}
+
+// LengthFieldBasedFrameCodec frames the messages by a length field, like the codec of the same name of Netty.
+type LengthFieldBasedFrameCodec struct {
+	encoderConfig EncoderConfig
+	decoderConfig DecoderConfig
+}
+
+// NewLengthFieldBasedFrameCodec returns a codec which encodes by encoderConfig and decodes by decoderConfig,
+// the byte order is big endian unless it is configured.
+func NewLengthFieldBasedFrameCodec(encoderConfig EncoderConfig, decoderConfig DecoderConfig) (*LengthFieldBasedFrameCodec, error) {
+	for _, length := range []int{encoderConfig.LengthFieldLength, decoderConfig.LengthFieldLength} {
+		switch length {
+		case 1, 2, 4, 8:
+		default:
+			return nil, errors.ErrUnsupportedLength
+		}
+	}
+	if encoderConfig.ByteOrder == nil {
+		encoderConfig.ByteOrder = binary.BigEndian
+	}
+	if decoderConfig.ByteOrder == nil {
+		decoderConfig.ByteOrder = binary.BigEndian
+	}
+	return &LengthFieldBasedFrameCodec{encoderConfig: encoderConfig, decoderConfig: decoderConfig}, nil
+}
+
+// Decode implements Codec.
+func (cc *LengthFieldBasedFrameCodec) Decode(_ Conn, buf []byte) (frames [][]byte, n int, err error) {
+	cfg := &cc.decoderConfig
+	fieldEnd := cfg.LengthFieldOffset + cfg.LengthFieldLength
+	for len(buf)-n >= fieldEnd {
+		length := readLength(cfg.ByteOrder, buf[n+cfg.LengthFieldOffset:n+fieldEnd])
+		// the length is checked before it is added up, a huge value must not wrap around.
+		if length > math.MaxInt32 {
+			return frames, n, errors.ErrTooLargeLength
+		}
+		frameLength := fieldEnd + int(length) + cfg.LengthAdjustment
+		if frameLength < fieldEnd || frameLength < cfg.InitialBytesToStrip {
+			return frames, n, errors.ErrTooLessLength
+		}
+		if len(buf)-n < frameLength {
+			break
+		}
+		frames = append(frames, buf[n+cfg.InitialBytesToStrip:n+frameLength])
+		n += frameLength
+	}
+	return frames, n, nil
+}
+
+// Encode implements Codec.
+func (cc *LengthFieldBasedFrameCodec) Encode(_ Conn, dst, msg []byte) ([]byte, error) {
+	cfg := &cc.encoderConfig
+	length := len(msg) + cfg.LengthAdjustment
+	if cfg.LengthIncludesLengthFieldLength {
+		length += cfg.LengthFieldLength
+	}
+	if length < 0 {
+		return dst, errors.ErrTooLessLength
+	}
+	if cfg.LengthFieldLength < 8 && uint64(length) >= 1<<(8*cfg.LengthFieldLength) {
+		return dst, errors.ErrTooLargeLength
+	}
+	var field [8]byte
+	switch cfg.LengthFieldLength {
+	case 1:
+		field[0] = byte(length)
+	case 2:
+		cfg.ByteOrder.PutUint16(field[:], uint16(length))
+	case 4:
+		cfg.ByteOrder.PutUint32(field[:], uint32(length))
+	case 8:
+		cfg.ByteOrder.PutUint64(field[:], uint64(length))
+	}
+	return append(append(dst, field[:cfg.LengthFieldLength]...), msg...), nil
+}
+
+// readLength reads the length field b.
+func readLength(order binary.ByteOrder, b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(order.Uint16(b))
+	case 4:
+		return uint64(order.Uint32(b))
+	}
+	return order.Uint64(b)
+}
@@ -1,9 +1,18 @@
+//go:build linux
+// +build linux
+
 package uringnet
 
 import (
 	"bytes"
-	"golang.org/x/sys/unix"
+	"crypto/tls"
+	"io"
 	"net"
+	"os"
+	"time"
+
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
 )
 
 type conn struct {
@@ -11,13 +20,451 @@ type conn struct {
 	ctx            interface{}   // user-defined context
 	peer           unix.Sockaddr // remote socket address
 	loop           *Ringloop     // connected event-loop
+	ringNet        *URingNet     // io_uring instance which owns the connection
 	buffer         []byte        // buffer for the latest bytes
+	readBuffer     []byte        // buffer which the kernel receives into when auto buffer is not used
 	opened         bool          // connection opened event fired
 	localAddr      net.Addr      // local addr
 	remoteAddr     net.Addr      // remote addr
 	isDatagram     bool          // UDP protocol
 	inboundBuffer  bytes.Buffer  //elastic.RingBuffer      // buffer for leftover data from the peer
-	outboundBuffer *bytes.Buffer //*elastic.Buffer         // buffer for data that is eligible to be sent to the peer
+	outboundBuffer outboundQueue //*elastic.Buffer         // buffer for data that is eligible to be sent to the peer
+	closing        bool          // close the connection as soon as the outbound buffer is flushed
+	reading        bool          // a read of the connection is in flight
+	multishot      uint64        // user data of the multishot receive which is armed, zero if there is none
+	received       bool          // some bytes have been received from the peer
+	readDeadline   time.Time     // reads which don't complete before it close the connection
+	writeDeadline  time.Time     // sends which don't complete before it close the connection
 	//pollAttachment *netpoll.PollAttachment // connection attachment for poller
-	rawSockAddr unix.RawSockaddrAny
+	rawSockAddr unix.RawSockaddrAny // address of the sender of the datagram
+	addrLen     uint32              // length of rawSockAddr
+	ephemeral   bool                // the connection stands for the sender of a datagram, it doesn't own the socket
+	sending     int                 // number of datagrams in flight
+	cred        *unix.Ucred         // credentials of the peer of a Unix socket
+	dialed      chan error          // delivers the outcome of the connect to Dial
+	pipe        [2]int              // pipe which files are spliced through, valid if pipeSize > 0
+	pipeSize    int                 // capacity of the pipe
+	piped       int                 // number of bytes in the pipe
+	sendBufs    [][]byte            // scratch slice of the byte slices gathered by a send
+	iovecs      []unix.Iovec        // iovecs of the byte slices gathered by the send in flight
+	iovHdr      unix.Msghdr         // message header of the gathered send in flight
+	tls         *tlsConn            // TLS layer of the connection, nil if it doesn't use TLS
+	codec       Codec               // codec of the frames, nil if the bytes aren't framed
+	encoded     []byte              // scratch buffer which messages are encoded into
+	decoding    bool                // OnTraffic is fired for a frame, the Reader methods see the frame only
+	frame       []byte              // unread bytes of the frame while decoding
+}
+
+var _ Conn = (*conn)(nil)
+
+func newTCPConn(fd int, ringNet *URingNet) *conn {
+	c := &conn{
+		fd:      fd,
+		loop:    ringNet.ringloop,
+		ringNet: ringNet,
+		codec:   ringNet.Codec,
+	}
+	if !ringNet.autoBuffer {
+		c.readBuffer = make([]byte, bufLength)
+	}
+	return c
+}
+
+// ================================== Reader ==================================
+
+// buffered returns all the bytes which haven't been consumed yet.
+// Either the inbound buffer or the latest bytes hold them, never both:
+// the latest bytes are appended to a non-empty inbound buffer as soon as they are read.
+func (c *conn) buffered() []byte {
+	if c.decoding {
+		return c.frame
+	}
+	if c.inboundBuffer.Len() > 0 {
+		return c.inboundBuffer.Bytes()
+	}
+	return c.buffer
+}
+
+// consume advances the unread bytes by n.
+func (c *conn) consume(n int) {
+	if c.decoding {
+		c.frame = c.frame[n:]
+		return
+	}
+	if c.inboundBuffer.Len() > 0 {
+		c.inboundBuffer.Next(n)
+		return
+	}
+	c.buffer = c.buffer[n:]
+}
+
+// feed makes the bytes just read from the peer available to the Reader methods.
+func (c *conn) feed(b []byte) {
+	if c.inboundBuffer.Len() > 0 {
+		_, _ = c.inboundBuffer.Write(b)
+		return
+	}
+	c.buffer = b
+}
+
+// saveLeftover moves the latest bytes which are not consumed by OnTraffic to the inbound buffer,
+// because the read buffer they are located in is going to be reused. Datagrams are never glued together,
+// the leftover of a datagram is dropped.
+func (c *conn) saveLeftover() {
+	if len(c.buffer) > 0 && !c.isDatagram {
+		_, _ = c.inboundBuffer.Write(c.buffer)
+	}
+	c.buffer = nil
+}
+
+func (c *conn) resetBuffer() {
+	if c.decoding {
+		c.frame = nil
+		return
+	}
+	c.buffer = c.buffer[:0]
+	c.inboundBuffer.Reset()
+}
+
+func (c *conn) Read(p []byte) (n int, err error) {
+	n = copy(p, c.buffered())
+	c.consume(n)
+	if n == 0 && len(p) > 0 {
+		err = io.ErrShortBuffer
+	}
+	return
+}
+
+func (c *conn) WriteTo(w io.Writer) (n int64, err error) {
+	var m int
+	m, err = w.Write(c.buffered())
+	n = int64(m)
+	c.consume(m)
+	return
+}
+
+// Next returns the next n bytes and consumes them, the entire buffer if fewer than n bytes are buffered or
+// n <= 0.
+func (c *conn) Next(n int) (buf []byte, err error) {
+	b := c.buffered()
+	if total := len(b); n > total || n <= 0 {
+		n = total
+	}
+	buf = b[:n]
+	c.consume(n)
+	return
+}
+
+func (c *conn) Peek(n int) (buf []byte, err error) {
+	b := c.buffered()
+	if total := len(b); n > total {
+		return nil, io.ErrShortBuffer
+	} else if n <= 0 {
+		n = total
+	}
+	return b[:n], nil
+}
+
+func (c *conn) Discard(n int) (int, error) {
+	total := len(c.buffered())
+	if n <= 0 || n >= total {
+		c.resetBuffer()
+		return total, nil
+	}
+	c.consume(n)
+	return n, nil
+}
+
+func (c *conn) InboundBuffered() int {
+	return len(c.buffered())
+}
+
+// ================================== Writer ==================================
+
+// Write appends p to the outbound buffer, the bytes are sent when the action returned by the
+// current callback is carried out, or when Flush is called. Every Write of a UDP connection is a datagram,
+// and a message which is encoded as one frame if the connection has a codec.
+// p is encrypted right away if the connection uses TLS.
+func (c *conn) Write(p []byte) (int, error) {
+	if c.isDatagram {
+		return c.outboundBuffer.WriteMessage(p)
+	}
+	if c.codec != nil {
+		frame, err := c.codec.Encode(c, c.encoded[:0], p)
+		if err != nil {
+			return 0, err
+		}
+		c.encoded = frame[:0]
+		if _, err = c.write(frame); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return c.write(p)
+}
+
+// write appends p to the outbound buffer as it is, apart from the encryption of TLS.
+func (c *conn) write(p []byte) (int, error) {
+	if c.tls != nil {
+		return c.tls.write(c, p)
+	}
+	return c.outboundBuffer.Write(p)
+}
+
+// Writev is like Write, the byte slices of a UDP connection are sent as one datagram, and they are encoded
+// as one frame if the connection has a codec.
+// The byte slices queued by Writes are gathered by one send, whether or not they are written by one Writev.
+func (c *conn) Writev(bs [][]byte) (n int, err error) {
+	if c.isDatagram {
+		return c.outboundBuffer.WriteMessage(bs...)
+	}
+	if c.codec != nil {
+		return c.Write(bytes.Join(bs, nil))
+	}
+	for _, b := range bs {
+		var m int
+		m, err = c.Write(b)
+		n += m
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ReadFrom reads r until EOF into the outbound buffer, as one datagram for a UDP connection and as one frame
+// if the connection has a codec.
+func (c *conn) ReadFrom(r io.Reader) (int64, error) {
+	if c.isDatagram {
+		b, err := io.ReadAll(r)
+		n, _ := c.outboundBuffer.WriteMessage(b)
+		return int64(n), err
+	}
+	if c.codec != nil {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		n, err := c.Write(b)
+		return int64(n), err
+	}
+	return c.readFrom(r)
+}
+
+// readFrom reads r until EOF into the outbound buffer as it is, apart from the encryption of TLS.
+func (c *conn) readFrom(r io.Reader) (int64, error) {
+	if c.encrypts() {
+		return io.Copy(writerFunc(c.write), r)
+	}
+	return c.outboundBuffer.ReadFrom(r)
+}
+
+// writerFunc turns a function into an io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// Flush submits the outbound buffer right away, a short send is continued by the ring until
+// everything is sent.
+func (c *conn) Flush() error {
+	c.ringNet.send(c)
+	_, err := c.ringNet.ring.Submit(0, &c.ringNet.enterFlags)
+	return err
+}
+
+// releaseIovecs drops the iovecs of a gathered send which has completed, they don't hold on to the bytes any more.
+func (c *conn) releaseIovecs() {
+	for i := range c.iovecs {
+		c.iovecs[i] = unix.Iovec{}
+	}
+	c.iovecs = c.iovecs[:0]
+	c.iovHdr = unix.Msghdr{}
+}
+
+func (c *conn) OutboundBuffered() int {
+	return c.outboundBuffer.Len()
+}
+
+// AsyncWrite queues buf on the ring which owns the connection, the bytes are written and sent on the ring's
+// goroutine and then callback is invoked there. buf must not be modified until callback is invoked.
+func (c *conn) AsyncWrite(buf []byte, callback AsyncCallback) error {
+	return c.async(func() {
+		_, _ = c.Write(buf)
+		c.ringNet.send(c)
+		_, _ = c.ringNet.ring.Submit(0, &c.ringNet.enterFlags)
+	}, callback)
+}
+
+// AsyncWritev is like AsyncWrite, but writes multiple byte slices.
+func (c *conn) AsyncWritev(bs [][]byte, callback AsyncCallback) error {
+	return c.async(func() {
+		_, _ = c.Writev(bs)
+		c.ringNet.send(c)
+		_, _ = c.ringNet.ring.Submit(0, &c.ringNet.enterFlags)
+	}, callback)
+}
+
+// async runs f on the ring's goroutine unless the connection has been closed by then, and then invokes
+// callback there with net.ErrClosed if f didn't run.
+func (c *conn) async(f func(), callback AsyncCallback) error {
+	return c.ringNet.trigger(func() {
+		var err error
+		if c.opened {
+			f()
+		} else {
+			err = net.ErrClosed
+		}
+		if callback != nil {
+			_ = callback(c, err)
+		}
+	})
+}
+
+// ================================== Socket ==================================
+
+func (c *conn) Fd() int {
+	return c.fd
+}
+
+func (c *conn) Dup() (int, error) {
+	return unix.FcntlInt(uintptr(c.fd), unix.F_DUPFD_CLOEXEC, 0)
+}
+
+func (c *conn) SetReadBuffer(bytes int) error {
+	return socket.SetRecvBuffer(c.fd, bytes)
+}
+
+func (c *conn) SetWriteBuffer(bytes int) error {
+	return socket.SetSendBuffer(c.fd, bytes)
+}
+
+func (c *conn) SetLinger(sec int) error {
+	return socket.SetLinger(c.fd, sec)
+}
+
+func (c *conn) SetKeepAlivePeriod(d time.Duration) error {
+	return socket.SetKeepAlivePeriod(c.fd, int(d.Seconds()))
+}
+
+func (c *conn) SetNoDelay(noDelay bool) error {
+	return socket.SetNoDelay(c.fd, boolToInt(noDelay))
+}
+
+func (c *conn) PeerCred() (*unix.Ucred, error) {
+	if c.cred == nil {
+		cred, err := unix.GetsockoptUcred(c.fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+		if err != nil {
+			return nil, os.NewSyscallError("getsockopt", err)
+		}
+		c.cred = cred
+	}
+	return c.cred, nil
+}
+
+// ================================== Conn ==================================
+
+func (c *conn) Context() interface{} {
+	return c.ctx
+}
+
+func (c *conn) SetContext(ctx interface{}) {
+	c.ctx = ctx
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	if c.localAddr == nil {
+		if sa, err := unix.Getsockname(c.fd); err == nil {
+			c.localAddr = c.toAddr(sa)
+		}
+	}
+	return c.localAddr
+}
+
+// RemoteAddr returns the address of the peer, which is the sender of the datagram for a UDP connection
+// of the socket the engine listens on.
+func (c *conn) RemoteAddr() net.Addr {
+	if c.remoteAddr == nil {
+		if c.peer == nil {
+			c.peer, _ = unix.Getpeername(c.fd)
+		}
+		if c.peer != nil {
+			c.remoteAddr = c.toAddr(c.peer)
+		}
+	}
+	return c.remoteAddr
+}
+
+func (c *conn) toAddr(sa unix.Sockaddr) net.Addr {
+	if c.isDatagram {
+		return socket.SockaddrToUDPAddr(sa)
+	}
+	return socket.SockaddrToTCPOrUnixAddr(sa)
+}
+
+// RingNet returns the ring which drives the connection.
+func (c *conn) RingNet() *URingNet {
+	return c.ringNet
+}
+
+// SetCodec attaches codec to the connection in place of the codec of the ring, nil stops framing.
+func (c *conn) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
+// ConnectionState returns the state of the TLS connection, such as the negotiated ALPN protocol and the
+// certificates of the peer, ok is false if the connection doesn't use TLS.
+func (c *conn) ConnectionState() (state tls.ConnectionState, ok bool) {
+	if c.tls == nil || !c.tls.established {
+		return tls.ConnectionState{}, false
+	}
+	return c.tls.state, true
+}
+
+// SetDeadline sets both the read and the write deadline, a zero value removes them.
+// The connection is closed with errors.ErrTimeout if a read or a send doesn't complete before the deadline.
+// Deadlines apply to the reads and sends submitted after the call, it is meant to be called in the callbacks.
+func (c *conn) SetDeadline(t time.Time) error {
+	c.readDeadline = t
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return nil
+}
+
+// Wake fires OnTraffic of the connection on the ring's goroutine, even though nothing has been received,
+// and then invokes callback there.
+func (c *conn) Wake(callback AsyncCallback) error {
+	return c.async(func() {
+		if c.codec != nil {
+			// OnTraffic sees no bytes, those of an incomplete frame are left to the codec.
+			c.ringNet.traffic(c, [][]byte{nil})
+		} else {
+			c.ringNet.response(c)
+		}
+	}, callback)
+}
+
+// Close closes the connection on the ring's goroutine and then invokes callback there.
+func (c *conn) Close(callback AsyncCallback) error {
+	return c.async(func() {
+		c.ringNet.close(c, nil)
+		_, _ = c.ringNet.ring.Submit(0, &c.ringNet.enterFlags)
+	}, callback)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,234 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/y001j/uringnet/errors"
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// message keeps what a RECVMSG or SENDMSG operation points to, it must stay untouched until the operation
+// completes, so it is referenced by the userdata of the operation rather than kept on the stack.
+type message struct {
+	hdr  unix.Msghdr
+	iov  unix.Iovec
+	addr unix.RawSockaddrAny // address of the sender of a received datagram, or the receiver of a sent one
+}
+
+// getMessage returns a spare message of the ring.
+func (ringNet *URingNet) getMessage() *message {
+	if n := len(ringNet.msgs); n > 0 {
+		m := ringNet.msgs[n-1]
+		ringNet.msgs = ringNet.msgs[:n-1]
+		return m
+	}
+	return new(message)
+}
+
+// putMessage keeps the message for the next operation.
+func (ringNet *URingNet) putMessage(m *message) {
+	*m = message{}
+	ringNet.msgs = append(ringNet.msgs, m)
+}
+
+// isDatagram reports whether the ring serves a UDP socket, which has no connections to accept.
+func (ringNet *URingNet) isDatagram() bool {
+	switch ringNet.Type {
+	case socket.Udp, socket.Udp4, socket.Udp6:
+		return true
+	}
+	return false
+}
+
+// newUDPConn creates the connection of a datagram. If m is not nil, the datagram is received by the socket the
+// engine listens on, the connection stands for its sender and only lives until the replies are sent.
+// Otherwise fd is a client-side socket connected to its peer.
+func newUDPConn(fd int, ringNet *URingNet, m *message) *conn {
+	c := &conn{
+		fd:         fd,
+		loop:       ringNet.ringloop,
+		ringNet:    ringNet,
+		isDatagram: true,
+	}
+	if m != nil {
+		c.ephemeral = true
+		c.opened = true
+		c.rawSockAddr = m.addr
+		c.addrLen = m.hdr.Namelen
+		c.peer = decodeSockaddr(&c.rawSockAddr)
+	}
+	return c
+}
+
+// recvMsg adds a receive of a datagram into SQEs and submits it, the datagram is put into a buffer picked from
+// the group of the ring. c is nil for the socket the engine listens on, whose datagrams come along with the
+// address of their sender.
+func (ringNet *URingNet) recvMsg(c *conn) {
+	m := ringNet.getMessage()
+	m.iov.SetLen(int(bufLength))
+	m.hdr.Iov = &m.iov
+	m.hdr.SetIovlen(1)
+
+	data := ringNet.ops.get(readMessage)
+	data.conn = c
+	data.msg = m
+	sqe := ringNet.getSQE()
+	sqe.SetUserData(data.id)
+	if c == nil {
+		m.hdr.Name = (*byte)(unsafe.Pointer(&m.addr))
+		m.hdr.Namelen = unix.SizeofSockaddrAny
+		// the socket the engine listens on is the registered file 0.
+		uring.RecvMsg(sqe, 0, &m.hdr, 0)
+		sqe.SetFlags(uring.IOSQE_FIXED_FILE | uring.IOSQE_BUFFER_SELECT)
+		ringNet.acceptID = data.id
+		ringNet.accepting = true
+	} else {
+		c.reading = true
+		uring.RecvMsg(sqe, uintptr(c.fd), &m.hdr, 0)
+		sqe.SetFlags(uring.IOSQE_BUFFER_SELECT)
+	}
+	sqe.SetBufGroup(ringNet.gid)
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+}
+
+// receive handles a received datagram. A datagram is never glued to another one, whatever OnTraffic leaves of it
+// is dropped. The socket the engine listens on goes on receiving unless the ring is shutting down.
+func (ringNet *URingNet) receive(c *conn, m *message, cqe uring.CQEntry) {
+	res := cqe.Result()
+	bid, picked := cqe.BufferID()
+	offset := uint64(bid)
+	if c == nil {
+		ringNet.accepting = false
+		shutdown := atomic.LoadInt32(&ringNet.inShutdown) == 1
+		switch {
+		case res >= 0 && !shutdown:
+			// replies are sent from the socket the datagram is received by.
+			uc := newUDPConn(ringNet.SocketFd, ringNet, m)
+			uc.feed(ringNet.buffer(bid)[:res])
+			ringNet.response(uc)
+		case res < 0 && res != -int32(unix.ECANCELED):
+			ringNet.logf("uringnet: receive datagram error: %v", resultError("recvmsg", res, false))
+		}
+		if picked {
+			ringNet.addBuffer(offset, ringNet.gid)
+		}
+		if !shutdown {
+			ringNet.recvMsg(nil)
+		}
+		return
+	}
+
+	c.reading = false
+	if res < 0 || !c.opened {
+		if picked {
+			ringNet.addBuffer(offset, ringNet.gid)
+		}
+		if res < 0 {
+			ringNet.close(c, resultError("recvmsg", res, false))
+			_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		}
+		return
+	}
+	c.feed(ringNet.buffer(bid)[:res])
+	ringNet.response(c)
+	ringNet.addBuffer(offset, ringNet.gid)
+}
+
+// sendMsgs adds a send of every datagram queued on the connection into SQEs. The datagrams go to the sender of
+// the datagram the connection stands for, or to the peer a client-side socket is connected to.
+func (ringNet *URingNet) sendMsgs(c *conn) {
+	for _, b := range c.outboundBuffer.bufs {
+		m := ringNet.getMessage()
+		if len(b) > 0 {
+			m.iov.Base = &b[0]
+		}
+		m.iov.SetLen(len(b))
+		m.hdr.Iov = &m.iov
+		m.hdr.SetIovlen(1)
+
+		data := ringNet.ops.get(writeMessage)
+		data.conn = c
+		data.msg = m
+		// the userdata keeps the bytes alive until the kernel has completed the send.
+		data.WriteBuf = b
+		sqe := ringNet.getSQE()
+		sqe.SetUserData(data.id)
+		if c.ephemeral {
+			m.addr = c.rawSockAddr
+			m.hdr.Name = (*byte)(unsafe.Pointer(&m.addr))
+			m.hdr.Namelen = c.addrLen
+			uring.SendMsg(sqe, 0, &m.hdr, 0)
+			sqe.SetFlags(uring.IOSQE_FIXED_FILE)
+		} else {
+			uring.SendMsg(sqe, uintptr(c.fd), &m.hdr, 0)
+		}
+		c.sending++
+	}
+	c.outboundBuffer.Reset()
+}
+
+// sent handles a completed send of a datagram, OnWritten fires once all the datagrams queued are sent.
+// A datagram which can't be sent is lost like any other datagram, the error is only logged.
+func (ringNet *URingNet) sent(c *conn, res int32) {
+	c.sending--
+	if res < 0 {
+		ringNet.logf("uringnet: send datagram error: %v", resultError("sendmsg", res, false))
+	}
+	if c.sending == 0 && c.opened {
+		ringNet.act(c, ringNet.Handler.OnWritten(c))
+	}
+}
+
+// DialUDP creates a client-side UDP socket connected to addr and hands it over to one of the rings of the loop,
+// where OnOpen fires. Every Write to the returned Conn is sent as a datagram to addr and every datagram sent back
+// fires OnTraffic. network is one of udp, udp4 and udp6.
+func (loop *Ringloop) DialUDP(network, addr string) (Conn, error) {
+	switch socket.NetAddressType(network) {
+	case socket.Udp, socket.Udp4, socket.Udp6:
+	default:
+		return nil, errors.ErrUnsupportedUDPProtocol
+	}
+	if atomic.LoadInt32(&loop.stopping) == 1 {
+		return nil, errors.ErrEngineShutdown
+	}
+	fd, _, err := socket.UDPSocket(network, addr, true)
+	if err != nil {
+		return nil, err
+	}
+	ringNet := loop.RingNet[int(atomic.AddUint32(&loop.next, 1))%len(loop.RingNet)]
+	c := newUDPConn(fd, ringNet, nil)
+	err = ringNet.trigger(func() {
+		if atomic.LoadInt32(&ringNet.inShutdown) == 1 {
+			_ = unix.Close(fd)
+			return
+		}
+		ringNet.addConn(c)
+		ringNet.open(c)
+	})
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return c, nil
+}
+
+// decodeSockaddr converts the address of the sender of a datagram, nil is returned for an unknown family.
+func decodeSockaddr(rsa *unix.RawSockaddrAny) unix.Sockaddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return &unix.SockaddrInet4{Port: int(p[0])<<8 + int(p[1]), Addr: pp.Addr}
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		return &unix.SockaddrInet6{Port: int(p[0])<<8 + int(p[1]), ZoneId: pp.Scope_id, Addr: pp.Addr}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+	socket "github.com/y001j/uringnet/sockets"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// Dial connects to addr through one of the rings of the loop and then drives the connection with the
+// EventHandler of the loop, OnOpen fires once it is connected just like for an accepted connection.
+// network is one of tcp, tcp4, tcp6 and unix, UDP networks are handed over to DialUDP.
+func (loop *Ringloop) Dial(network, addr string) (Conn, error) {
+	return loop.DialContext(context.Background(), network, addr)
+}
+
+// DialTimeout is like Dial, but gives up if the connect doesn't complete within timeout.
+func (loop *Ringloop) DialTimeout(network, addr string, timeout time.Duration) (Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return loop.DialContext(ctx, network, addr)
+}
+
+// DialContext is like Dial, the connect is canceled if ctx is done before it completes. The deadline of ctx is
+// enforced by the ring with a timeout linked to the connect.
+//
+// The returned Conn belongs to the ring, other goroutines may only call its asynchronous methods,
+// i.e. AsyncWrite, AsyncWritev, Wake and Close.
+func (loop *Ringloop) DialContext(ctx context.Context, network, addr string) (Conn, error) {
+	switch socket.NetAddressType(network) {
+	case socket.Udp, socket.Udp4, socket.Udp6:
+		return loop.DialUDP(network, addr)
+	case socket.Tcp, socket.Tcp4, socket.Tcp6, socket.Unix:
+	default:
+		return nil, errors.ErrUnsupportedProtocol
+	}
+	if atomic.LoadInt32(&loop.stopping) == 1 {
+		return nil, errors.ErrEngineShutdown
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+
+	fd, sa, err := socket.ClientSocket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	ringNet := loop.RingNet[int(atomic.AddUint32(&loop.next, 1))%len(loop.RingNet)]
+	c := &conn{
+		fd:      fd,
+		peer:    sa,
+		loop:    loop,
+		ringNet: ringNet,
+		codec:   ringNet.Codec,
+		dialed:  make(chan error, 1),
+	}
+	rsa, addrLen, err := sockaddrToAny(sa)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	c.rawSockAddr, c.addrLen = *rsa, uint32(addrLen)
+	if err = ringNet.trigger(func() { ringNet.connect(c, timeout) }); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	done := ctx.Done()
+wait:
+	for {
+		select {
+		case err = <-c.dialed:
+			break wait
+		case <-done:
+			// the connect completes with -ECANCELED, the outcome is still delivered.
+			done = nil
+			_ = ringNet.trigger(func() { ringNet.cancelConnect(c) })
+		case <-ringNet.done:
+			// a connect which has been submitted is always completed before the ring leaves its running cycle.
+			select {
+			case err = <-c.dialed:
+			default:
+				_ = unix.Close(fd)
+				err = errors.ErrEngineShutdown
+			}
+			break wait
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect submits the connect of a socket created by Dial, the outcome is delivered to c.dialed.
+func (ringNet *URingNet) connect(c *conn, timeout time.Duration) {
+	if atomic.LoadInt32(&ringNet.inShutdown) == 1 {
+		_ = unix.Close(c.fd)
+		c.dialed <- errors.ErrEngineShutdown
+		return
+	}
+	if !ringNet.autoBuffer {
+		c.readBuffer = make([]byte, bufLength)
+	}
+	data := ringNet.ops.get(connecting)
+	data.conn = c
+	sqe := ringNet.getSQE()
+	sqe.SetUserData(data.id)
+	uring.Connect(sqe, uintptr(c.fd), &c.rawSockAddr, c.addrLen)
+	if timeout > 0 {
+		ringNet.linkTimeout(sqe, data, timeout)
+	}
+	if ringNet.dials == nil {
+		ringNet.dials = make(map[*conn]uint64)
+	}
+	ringNet.dials[c] = data.id
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+}
+
+// cancelConnect cancels the connect of c if it is still in flight.
+func (ringNet *URingNet) cancelConnect(c *conn) {
+	if id, ok := ringNet.dials[c]; ok {
+		uring.AsyncCancel(ringNet.getSQE(), id)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+	}
+}
+
+// connected opens the connection once its connect succeeds, otherwise the socket is closed.
+// Either way Dial learns the outcome before OnOpen fires.
+func (ringNet *URingNet) connected(c *conn, res int32, timed bool) {
+	delete(ringNet.dials, c)
+	var err error
+	if atomic.LoadInt32(&ringNet.inShutdown) == 1 {
+		err = errors.ErrEngineShutdown
+	} else if res < 0 {
+		err = resultError("connect", res, timed)
+	}
+	if err != nil {
+		_ = unix.Close(c.fd)
+		c.dialed <- err
+		return
+	}
+	ringNet.addConn(c)
+	c.dialed <- nil
+	ringNet.open(c)
+}
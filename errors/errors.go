@@ -14,7 +14,10 @@
 
 package errors
 
-import "errors"
+import (
+	"errors"
+	"os"
+)
 
 var (
 	// ErrEngineShutdown occurs when server is closing.
@@ -39,4 +42,19 @@ var (
 	ErrUnsupportedOp = errors.New("unsupported operation")
 	// ErrNegativeSize occurs when trying to pass a negative size to a buffer.
 	ErrNegativeSize = errors.New("negative size is invalid")
+	// ErrEmptyDelimiter occurs when the delimiter of the frames of a delimiter-based codec is empty.
+	ErrEmptyDelimiter = errors.New("empty delimiter of frames")
+	// ErrInvalidFixedLength occurs when the length of the frames of a fixed-length codec is not positive.
+	ErrInvalidFixedLength = errors.New("invalid fixed length of frames")
+	// ErrUnexpectedFrameLength occurs when a message doesn't fit the fixed length of the frames.
+	ErrUnexpectedFrameLength = errors.New("the message doesn't fit the fixed length of the frames")
+	// ErrUnsupportedLength occurs when the length field of a frame is not 1, 2, 4 or 8 bytes long.
+	ErrUnsupportedLength = errors.New("unsupported length of the length field, only 1, 2, 4 and 8 are supported")
+	// ErrTooLessLength occurs when the adjusted length of a frame is less than its header.
+	ErrTooLessLength = errors.New("the adjusted length of the frame is less than its header")
+	// ErrTooLargeLength occurs when the length of a frame is too large for the length field or to be decoded.
+	ErrTooLargeLength = errors.New("the length of the frame is too large")
+	// ErrTimeout occurs when a connection is closed because a read or a write doesn't complete in time,
+	// it is os.ErrDeadlineExceeded, whose Timeout method reports true.
+	ErrTimeout = os.ErrDeadlineExceeded
 )
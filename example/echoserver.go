@@ -9,7 +9,7 @@ import (
 )
 
 type testServer struct {
-	UringNet.BuiltinEventEngine
+	uringnet.BuiltinEventEngine
 
 	testloop *uringnet.Ringloop
 	//ring      *uring_net.URingNet
@@ -21,14 +21,15 @@ type testServer struct {
 //
 //	@Description:
 //	@receiver ts
-//	@param data
+//	@param c
 //	@return uring_net.Action
-func (ts *testServer) OnTraffic(data *uringnet.UserData, ringnet *uringnet.URingNet) uringnet.Action {
-	data.WriteBuf = data.Buffer
+func (ts *testServer) OnTraffic(c uringnet.Conn) uringnet.Action {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
 	return uringnet.Echo
 }
 
-func (ts *testServer) OnWritten(data uringnet.UserData) UringNet.Action {
+func (ts *testServer) OnWritten(c uringnet.Conn) uringnet.Action {
 	//buf, _ := c.Next(-1)
 	//thebuffer := ts.testloop.GetBuffer()
 	//fmt.Println("Send Message to Client: \n", string(data))
@@ -38,7 +39,7 @@ func (ts *testServer) OnWritten(data uringnet.UserData) UringNet.Action {
 	return uringnet.None
 }
 
-func (ts *testServer) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+func (ts *testServer) OnOpen(c uringnet.Conn) ([]byte, uringnet.Action) {
 	//buf, _ := c.Next(-1)
 	//thebuffer := ts.testloop.GetBuffer()
 	//fmt.Println("Send Message to Client: \n", string(data))
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	uringnet "github.com/y001j/uringnet"
 	socket "github.com/y001j/uringnet/sockets"
 	"os"
@@ -18,9 +17,15 @@ type testServer struct {
 	multicore bool
 }
 
+// httpCodec splits the pipelined requests at the blank line which ends their headers,
+// the responses are written as they are.
 type httpCodec struct {
-	delimiter []byte
-	buf       []byte
+	*uringnet.DelimiterBasedFrameCodec
+	buf []byte
+}
+
+func (hc *httpCodec) Encode(_ uringnet.Conn, dst, msg []byte) ([]byte, error) {
+	return append(dst, msg...), nil
 }
 
 func appendResponse(hc *[]byte) {
@@ -34,36 +39,27 @@ var (
 	errMsgBytes = []byte(errMsg)
 )
 
-func (ts *testServer) OnTraffic(data *uringnet.UserData, ringnet *uringnet.URingNet) uringnet.Action {
-
-	//将data.Buffer转换为string
-	//buffer := data.Buffer[:data.BufSize]
-
-	buffer := ringnet.ReadBuffer
-	//tes :=
-	//fmt.Println("data:", " offset: ", tes, " ", data.BufOffset)
-	//获取tes中“\r\n\r\n”的数量
-	count := bytes.Count(buffer, []byte("GET"))
-	if count == 0 {
-		//appendResponse(&data.WriteBuf)
-		//return UringNet.Close
-		return uringnet.None
-	} else {
-		for i := 0; i < count; i++ {
-			appendResponse(&data.WriteBuf)
-		}
-	}
+func (ts *testServer) OnTraffic(c uringnet.Conn) uringnet.Action {
+	hc := c.Context().(*httpCodec)
+
+	// OnTraffic fires once for every complete request, a partial one is kept by the codec until the rest arrives.
+	hc.buf = hc.buf[:0]
+	appendResponse(&hc.buf)
+	_, _ = c.Write(hc.buf)
 	return uringnet.Echo
 }
 
-func (ts *testServer) OnWritten(data uringnet.UserData) uringnet.Action {
+func (ts *testServer) OnWritten(c uringnet.Conn) uringnet.Action {
 
 	return uringnet.None
 }
 
-func (ts *testServer) OnOpen(data *uringnet.UserData) ([]byte, uringnet.Action) {
+func (ts *testServer) OnOpen(c uringnet.Conn) ([]byte, uringnet.Action) {
 
-	ts.SetContext(&httpCodec{delimiter: []byte("\r\n\r\n")})
+	delimiter, _ := uringnet.NewDelimiterBasedFrameCodec([]byte("\r\n\r\n"))
+	hc := &httpCodec{DelimiterBasedFrameCodec: delimiter}
+	c.SetContext(hc)
+	c.SetCodec(hc)
 	return nil, uringnet.None
 }
 
@@ -0,0 +1,354 @@
+//go:build linux
+// +build linux
+
+package http
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Header is the header of a request or a response, the keys are in the canonical form of
+// textproto.CanonicalMIMEHeaderKey.
+type Header map[string][]string
+
+// Add adds the value to the values of key.
+func (h Header) Add(key, value string) {
+	textproto.MIMEHeader(h).Add(key, value)
+}
+
+// Set replaces the values of key by value.
+func (h Header) Set(key, value string) {
+	textproto.MIMEHeader(h).Set(key, value)
+}
+
+// Get returns the first value of key, or "" if there is none.
+func (h Header) Get(key string) string {
+	return textproto.MIMEHeader(h).Get(key)
+}
+
+// Values returns all the values of key.
+func (h Header) Values(key string) []string {
+	return textproto.MIMEHeader(h).Values(key)
+}
+
+// Del deletes the values of key.
+func (h Header) Del(key string) {
+	textproto.MIMEHeader(h).Del(key)
+}
+
+// hasToken reports whether one of the comma-separated values of key is token, regardless of the case.
+func (h Header) hasToken(key, token string) bool {
+	for _, v := range h[key] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Request is an HTTP request received by the server.
+type Request struct {
+	Method     string   // method of the request, such as GET
+	URL        *url.URL // URL parsed from RequestURI
+	RequestURI string   // request target of the request line as it is sent by the client
+	Proto      string   // protocol version, such as HTTP/1.1
+	ProtoMajor int
+	ProtoMinor int
+	Header     Header
+	Host       string // host of the absolute URL or of the Host header
+
+	// Body is the whole body of the request, decoded if it is chunked. It is only valid until ServeHTTP returns.
+	Body []byte
+	// ContentLength is the value of the Content-Length header, or -1 if the body is chunked.
+	ContentLength    int64
+	TransferEncoding []string // transfer codings of the body, i.e. chunked or nothing
+	Trailer          Header   // fields of the trailer of a chunked body
+
+	// Close reports whether the client asks for the connection to be closed after the response.
+	Close bool
+
+	RemoteAddr string               // address of the client
+	TLS        *tls.ConnectionState // state of the TLS connection, nil if the request is not received over TLS
+
+	expectContinue bool // the client waits for 100 Continue before it sends the body
+}
+
+// ProtoAtLeast reports whether the protocol version of the request is at least major.minor.
+func (r *Request) ProtoAtLeast(major, minor int) bool {
+	return r.ProtoMajor > major || r.ProtoMajor == major && r.ProtoMinor >= minor
+}
+
+// phases of the parser.
+const (
+	readingHeader = iota
+	readingBody
+	readingChunkSize
+	readingChunk
+	readingChunkEnd
+	readingTrailer
+)
+
+// maxChunkLineBytes limits the line of the size of a chunk, including its extensions.
+const maxChunkLineBytes = 4096
+
+// parser parses the requests of a connection incrementally, the bytes of a request may arrive by many reads
+// and a read may carry many requests.
+type parser struct {
+	phase          int
+	scanned        int   // number of bytes of the header which have been searched for its end
+	remaining      int64 // bytes left of the body or of the current chunk
+	trailerBytes   int   // bytes of the trailer so far
+	maxHeaderBytes int
+	maxBodyBytes   int64
+	req            Request
+	body           []byte // body decoded from the chunks so far
+}
+
+// parse parses the buffered bytes buf of a connection, it returns the number of bytes the parser has taken from
+// buf and reports whether a request is complete then. The body of a complete request may refer to buf.
+func (p *parser) parse(buf []byte) (n int, done bool, err error) {
+	for {
+		switch p.phase {
+		case readingHeader:
+			// the end may straddle the bytes which have been searched already.
+			from := p.scanned - 3
+			if from < 0 {
+				from = 0
+			}
+			i := bytes.Index(buf[from:], []byte("\r\n\r\n"))
+			if i < 0 {
+				if len(buf) > p.maxHeaderBytes {
+					return 0, false, errHeaderTooLarge
+				}
+				p.scanned = len(buf)
+				return 0, false, nil
+			}
+			end := from + i + 4
+			if end > p.maxHeaderBytes {
+				return 0, false, errHeaderTooLarge
+			}
+			if err = p.readHeader(buf[:end-4]); err != nil {
+				return 0, false, err
+			}
+			p.scanned = 0
+			n = end
+			switch {
+			case p.req.ContentLength < 0:
+				p.phase = readingChunkSize
+			case p.req.ContentLength > 0:
+				p.phase, p.remaining = readingBody, p.req.ContentLength
+			default:
+				return n, true, nil
+			}
+		case readingBody:
+			// the body is taken as it is once it is all buffered.
+			if int64(len(buf)-n) < p.remaining {
+				return n, false, nil
+			}
+			p.req.Body = buf[n : n+int(p.remaining)]
+			return n + int(p.remaining), true, nil
+		case readingChunkSize:
+			i := bytes.Index(buf[n:], []byte("\r\n"))
+			if i < 0 {
+				if len(buf)-n > maxChunkLineBytes {
+					return n, false, errBadChunk
+				}
+				return n, false, nil
+			}
+			line := buf[n : n+i]
+			if j := bytes.IndexByte(line, ';'); j >= 0 {
+				line = line[:j]
+			}
+			size, err := strconv.ParseInt(string(bytes.TrimSpace(line)), 16, 64)
+			if err != nil || size < 0 {
+				return n, false, errBadChunk
+			}
+			n += i + 2
+			if size == 0 {
+				p.phase = readingTrailer
+				continue
+			}
+			if size > p.maxBodyBytes-int64(len(p.body)) {
+				return n, false, errBodyTooLarge
+			}
+			p.phase, p.remaining = readingChunk, size
+		case readingChunk:
+			// the data of a chunk is taken as it arrives.
+			k := len(buf) - n
+			if int64(k) > p.remaining {
+				k = int(p.remaining)
+			}
+			p.body = append(p.body, buf[n:n+k]...)
+			n += k
+			if p.remaining -= int64(k); p.remaining > 0 {
+				return n, false, nil
+			}
+			p.phase = readingChunkEnd
+		case readingChunkEnd:
+			if len(buf)-n < 2 {
+				return n, false, nil
+			}
+			if buf[n] != '\r' || buf[n+1] != '\n' {
+				return n, false, errBadChunk
+			}
+			n += 2
+			p.phase = readingChunkSize
+		case readingTrailer:
+			i := bytes.Index(buf[n:], []byte("\r\n"))
+			if i < 0 {
+				if p.trailerBytes+len(buf)-n > p.maxHeaderBytes {
+					return n, false, errHeaderTooLarge
+				}
+				return n, false, nil
+			}
+			if p.trailerBytes += i + 2; p.trailerBytes > p.maxHeaderBytes {
+				return n, false, errHeaderTooLarge
+			}
+			line := buf[n : n+i]
+			n += i + 2
+			if len(line) == 0 {
+				p.req.Body = p.body
+				return n, true, nil
+			}
+			if p.req.Trailer == nil {
+				p.req.Trailer = make(Header)
+			}
+			if err = readField(p.req.Trailer, line); err != nil {
+				return n, false, err
+			}
+		}
+	}
+}
+
+// readHeader parses the request line and the header fields of a request, b ends before the empty line.
+func (p *parser) readHeader(b []byte) error {
+	r := &p.req
+	requestLine, rest, _ := cut(b)
+	method, target, ok1 := strings.Cut(string(requestLine), " ")
+	target, proto, ok2 := strings.Cut(target, " ")
+	if !ok1 || !ok2 || !validToken(method) || target == "" {
+		return errBadRequest
+	}
+	major, minor, ok := parseHTTPVersion(proto)
+	if !ok {
+		return errBadRequest
+	}
+	if major != 1 {
+		return errVersionNotSupported
+	}
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return errBadRequest
+	}
+	r.Method, r.RequestURI, r.URL = method, target, u
+	r.Proto, r.ProtoMajor, r.ProtoMinor = proto, major, minor
+	r.Header = make(Header)
+	for len(rest) > 0 {
+		var line []byte
+		line, rest, _ = cut(rest)
+		if err = readField(r.Header, line); err != nil {
+			return err
+		}
+	}
+
+	hosts := r.Header["Host"]
+	if len(hosts) > 1 || len(hosts) == 0 && r.ProtoAtLeast(1, 1) {
+		return errBadRequest
+	}
+	if r.Host = u.Host; r.Host == "" && len(hosts) == 1 {
+		r.Host = hosts[0]
+	}
+	if r.ProtoAtLeast(1, 1) {
+		r.Close = r.Header.hasToken("Connection", "close")
+	} else {
+		r.Close = !r.Header.hasToken("Connection", "keep-alive")
+	}
+
+	if te := r.Header["Transfer-Encoding"]; len(te) > 0 {
+		// a body framed by both the length and the chunks could be read differently by a proxy.
+		if len(te) > 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			return errNotImplemented
+		}
+		if _, ok := r.Header["Content-Length"]; ok {
+			return errBadRequest
+		}
+		r.TransferEncoding, r.ContentLength = []string{"chunked"}, -1
+	} else if cl := r.Header["Content-Length"]; len(cl) > 0 {
+		for _, v := range cl[1:] {
+			if v != cl[0] {
+				return errBadRequest
+			}
+		}
+		length, err := strconv.ParseUint(strings.TrimSpace(cl[0]), 10, 63)
+		if err != nil {
+			return errBadRequest
+		}
+		if int64(length) > p.maxBodyBytes {
+			return errBodyTooLarge
+		}
+		r.ContentLength = int64(length)
+	}
+
+	if expect := r.Header.Get("Expect"); expect != "" {
+		if !strings.EqualFold(expect, "100-continue") {
+			return errExpectationFailed
+		}
+		r.expectContinue = r.ProtoAtLeast(1, 1) && r.ContentLength != 0
+	}
+	return nil
+}
+
+// readField adds the header field of line to h, an obsolete folded line is rejected.
+func readField(h Header, line []byte) error {
+	name, value, ok := bytes.Cut(line, []byte(":"))
+	if !ok || !validToken(string(name)) {
+		return errBadRequest
+	}
+	key := textproto.CanonicalMIMEHeaderKey(string(name))
+	h[key] = append(h[key], string(bytes.Trim(value, " \t")))
+	return nil
+}
+
+// reset makes the parser ready for the next request of the connection.
+func (p *parser) reset() {
+	p.phase, p.scanned, p.remaining, p.trailerBytes = readingHeader, 0, 0, 0
+	p.req = Request{}
+	p.body = p.body[:0]
+}
+
+// cut splits b at the first CRLF.
+func cut(b []byte) (line, rest []byte, found bool) {
+	return bytes.Cut(b, []byte("\r\n"))
+}
+
+// parseHTTPVersion parses a version such as HTTP/1.1.
+func parseHTTPVersion(proto string) (major, minor int, ok bool) {
+	if len(proto) != len("HTTP/1.1") || !strings.HasPrefix(proto, "HTTP/") || proto[6] != '.' ||
+		proto[5] < '0' || proto[5] > '9' || proto[7] < '0' || proto[7] > '9' {
+		return 0, 0, false
+	}
+	return int(proto[5] - '0'), int(proto[7] - '0'), true
+}
+
+// validToken reports whether s is a token of RFC 7230, which a method or a field name must be.
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
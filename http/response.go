@@ -0,0 +1,243 @@
+//go:build linux
+// +build linux
+
+package http
+
+import (
+	"errors"
+	nethttp "net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/y001j/uringnet"
+)
+
+var (
+	// ErrBodyNotAllowed is returned by Write when the status of the response doesn't allow a body.
+	ErrBodyNotAllowed = errors.New("http: request method or response status code does not allow body")
+	// ErrContentLength is returned by Write when more bytes are written than the Content-Length header declares.
+	ErrContentLength = errors.New("http: wrote more than the declared Content-Length")
+)
+
+// TimeFormat is the time format of the Date header.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ResponseWriter is used by a Handler to construct the response of a request.
+type ResponseWriter interface {
+	// Header returns the header which is sent by WriteHeader, changes after that have no effect.
+	Header() Header
+
+	// Write appends p to the body of the response, WriteHeader(200) is called first if it hasn't been called.
+	// The body is buffered until ServeHTTP returns or the response is flushed.
+	Write(p []byte) (int, error)
+
+	// WriteHeader sets the status code of the response, only the first call takes effect.
+	// An informational status such as 103 is sent right away and doesn't count as the first call.
+	WriteHeader(statusCode int)
+}
+
+// Flusher is implemented by the ResponseWriter of the server. Flush sends the response written so far, the rest
+// of the body is sent in chunks then, unless its length is declared by the Content-Length header. The chunks
+// of an HTTP/1.0 client are sent as they are and the connection is closed after the response.
+type Flusher interface {
+	Flush()
+}
+
+// response is the ResponseWriter of a request.
+type response struct {
+	c             uringnet.Conn
+	req           *Request
+	header        Header
+	status        int
+	wroteHeader   bool  // the status is set
+	sentHeader    bool  // the header has been written to the connection
+	chunked       bool  // the body after the header is sent in chunks
+	contentLength int64 // length declared by the Content-Length header, -1 if there is none
+	written       int64 // bytes of the body written by the handler
+	body          []byte
+	buf           []byte // scratch buffer of the header and the chunk sizes
+	close         bool   // the connection is closed after the response
+}
+
+func (w *response) Header() Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(code int) {
+	if code < 100 || code > 999 {
+		panic("http: invalid WriteHeader code " + strconv.Itoa(code))
+	}
+	if w.wroteHeader {
+		return
+	}
+	if code < 200 && code != nethttp.StatusSwitchingProtocols {
+		w.buf = appendStatusLine(w.buf[:0], code)
+		w.buf = appendFields(w.buf, w.header)
+		w.buf = append(w.buf, "\r\n"...)
+		_, _ = w.c.Write(w.buf)
+		return
+	}
+	w.wroteHeader, w.status = true, code
+	w.contentLength = -1
+	if cl := w.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseUint(strings.TrimSpace(cl), 10, 63); err == nil {
+			w.contentLength = int64(n)
+		} else {
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(nethttp.StatusOK)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	w.written += int64(len(p))
+	if w.contentLength >= 0 && w.written > w.contentLength {
+		return 0, ErrContentLength
+	}
+	if w.req.Method == "HEAD" {
+		return len(p), nil
+	}
+	if !w.sentHeader {
+		w.body = append(w.body, p...)
+		return len(p), nil
+	}
+	w.writeBody(p)
+	return len(p), nil
+}
+
+func (w *response) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(nethttp.StatusOK)
+	}
+	if !w.sentHeader {
+		w.writeHeader(false)
+		w.writeBody(w.body)
+		w.body = w.body[:0]
+	}
+	_ = w.c.Flush()
+}
+
+// finish completes the response once the handler has returned.
+func (w *response) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(nethttp.StatusOK)
+	}
+	if !w.sentHeader {
+		w.writeHeader(true)
+		w.writeBody(w.body)
+	} else if w.chunked {
+		_, _ = w.c.Write([]byte("0\r\n\r\n"))
+	}
+	// the client would wait for the missing bytes forever.
+	if w.contentLength >= 0 && w.written < w.contentLength && w.req.Method != "HEAD" {
+		w.close = true
+	}
+}
+
+// writeHeader writes the status line and the header, the framing of the body is known if the handler
+// has returned.
+func (w *response) writeHeader(final bool) {
+	w.sentHeader = true
+	h := w.header
+	h.Del("Transfer-Encoding")
+	if h.hasToken("Connection", "close") {
+		w.close = true
+	}
+	h.Del("Connection")
+	if _, ok := h["Date"]; !ok {
+		h.Set("Date", time.Now().UTC().Format(TimeFormat))
+	}
+	if bodyAllowed(w.status) {
+		if _, ok := h["Content-Type"]; !ok && len(w.body) > 0 {
+			h.Set("Content-Type", nethttp.DetectContentType(w.body))
+		}
+		switch {
+		case w.contentLength >= 0:
+		case final && (w.req.Method != "HEAD" || w.written > 0):
+			h.Set("Content-Length", strconv.FormatInt(w.written, 10))
+		case final:
+		case w.req.ProtoAtLeast(1, 1):
+			w.chunked = true
+			h.Set("Transfer-Encoding", "chunked")
+		default:
+			// an HTTP/1.0 client reads the body until the connection is closed.
+			w.close = true
+		}
+	} else {
+		h.Del("Content-Length")
+	}
+	if w.close {
+		h.Set("Connection", "close")
+	} else if !w.req.ProtoAtLeast(1, 1) {
+		h.Set("Connection", "keep-alive")
+	}
+	w.buf = appendStatusLine(w.buf[:0], w.status)
+	w.buf = appendFields(w.buf, h)
+	w.buf = append(w.buf, "\r\n"...)
+	_, _ = w.c.Write(w.buf)
+}
+
+// writeBody writes p after the header, as a chunk if the body is chunked.
+func (w *response) writeBody(p []byte) {
+	if len(p) == 0 || w.req.Method == "HEAD" {
+		return
+	}
+	if w.chunked {
+		w.buf = strconv.AppendInt(w.buf[:0], int64(len(p)), 16)
+		w.buf = append(w.buf, "\r\n"...)
+		_, _ = w.c.Writev([][]byte{w.buf, p, []byte("\r\n")})
+		return
+	}
+	_, _ = w.c.Write(p)
+}
+
+// appendStatusLine appends the status line of code to b.
+func appendStatusLine(b []byte, code int) []byte {
+	b = append(b, "HTTP/1.1 "...)
+	b = strconv.AppendInt(b, int64(code), 10)
+	b = append(b, ' ')
+	if text := nethttp.StatusText(code); text != "" {
+		b = append(b, text...)
+	} else {
+		b = append(b, "status code "...)
+		b = strconv.AppendInt(b, int64(code), 10)
+	}
+	return append(b, "\r\n"...)
+}
+
+// appendFields appends the fields of h to b in the order of their keys, a line break of a value is replaced
+// by a space so that it can't inject fields.
+func appendFields(b []byte, h Header) []byte {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			b = append(b, k...)
+			b = append(b, ": "...)
+			for i := 0; i < len(v); i++ {
+				if c := v[i]; c == '\r' || c == '\n' {
+					b = append(b, ' ')
+				} else {
+					b = append(b, c)
+				}
+			}
+			b = append(b, "\r\n"...)
+		}
+	}
+	return b
+}
+
+// bodyAllowed reports whether a response of status may have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != nethttp.StatusNoContent && status != nethttp.StatusNotModified
+}
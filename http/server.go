@@ -0,0 +1,190 @@
+//go:build linux
+// +build linux
+
+// Package http serves HTTP/1.1 over the rings of uringnet. A Server is the EventHandler of the rings: it parses
+// the requests incrementally as their bytes arrive, answers the requests pipelined by a client in order and keeps
+// the connections alive between them. Chunked request bodies are decoded, and a response which is flushed before
+// its handler returns is sent in chunks.
+//
+// The server is configured by the rings it runs on, like net/http.Server:
+//   - MaxHeaderBytes limits the request line and the header fields of a request, DefaultMaxHeaderBytes
+//     applies if it is zero;
+//   - ReadHeaderTimeout, ReadTimeout, IdleTimeout and WriteTimeout limit the reads and the sends of the
+//     connections;
+//   - SetKeepAlivesEnabled(false) closes every connection after its current response, and so does the
+//     shutdown of the loop;
+//   - TLSConfig serves HTTPS, "http/1.1" is offered by ALPN.
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	nethttp "net/http"
+	"runtime"
+
+	"github.com/y001j/uringnet"
+)
+
+const (
+	// DefaultMaxHeaderBytes is the limit of the header of a request if URingNet.MaxHeaderBytes is zero.
+	DefaultMaxHeaderBytes = 1 << 20
+	// DefaultMaxBodyBytes is the limit of the body of a request if Server.MaxBodyBytes is zero.
+	DefaultMaxBodyBytes = 32 << 20
+)
+
+// Handler responds to an HTTP request.
+//
+// ServeHTTP runs on the goroutine of the ring, it must not block. The request and its body are only valid until
+// ServeHTTP returns, the response is sent then unless it has been flushed before.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc turns a function into a Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeHTTP calls f(w, r).
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// Server is the EventHandler of the rings which serve HTTP/1.1, it owns the context of the connections.
+type Server struct {
+	uringnet.BuiltinEventEngine
+
+	Handler      Handler // handler of the requests
+	MaxBodyBytes int64   // limit of the body of a request, which is buffered before the handler is called
+}
+
+// NewServer returns a server whose requests are handled by handler.
+func NewServer(handler Handler) *Server {
+	return &Server{Handler: handler}
+}
+
+// statusError is an error of a request which is answered with its status before the connection is closed.
+type statusError struct {
+	status int
+	text   string
+}
+
+func (e *statusError) Error() string {
+	return e.text
+}
+
+var (
+	errBadRequest          = &statusError{nethttp.StatusBadRequest, "malformed request"}
+	errBadChunk            = &statusError{nethttp.StatusBadRequest, "malformed chunked encoding"}
+	errHeaderTooLarge      = &statusError{nethttp.StatusRequestHeaderFieldsTooLarge, "request header too large"}
+	errBodyTooLarge        = &statusError{nethttp.StatusRequestEntityTooLarge, "request body too large"}
+	errExpectationFailed   = &statusError{nethttp.StatusExpectationFailed, "unsupported expectation"}
+	errNotImplemented      = &statusError{nethttp.StatusNotImplemented, "unsupported transfer encoding"}
+	errVersionNotSupported = &statusError{nethttp.StatusHTTPVersionNotSupported, "unsupported protocol version"}
+)
+
+// serverConn is the state of a connection of the server.
+type serverConn struct {
+	parser
+	continued bool // 100 Continue has been sent for the current request
+	w         response
+}
+
+// OnBoot prepares the ring for HTTP/1.1, a datagram ring shuts the loop down.
+func (srv *Server) OnBoot(eng *uringnet.URingNet) uringnet.Action {
+	if err := eng.SetupNextProto("http/1.1"); err != nil {
+		logf(eng, "uringnet/http: can't serve %s: %v", eng.Type, err)
+		return uringnet.Shutdown
+	}
+	return uringnet.None
+}
+
+// OnOpen attaches the state of the server to the connection.
+func (srv *Server) OnOpen(c uringnet.Conn) ([]byte, uringnet.Action) {
+	maxHeaderBytes := c.RingNet().MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	maxBodyBytes := srv.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+	c.SetContext(&serverConn{parser: parser{maxHeaderBytes: maxHeaderBytes, maxBodyBytes: maxBodyBytes}})
+	return nil, uringnet.None
+}
+
+// OnTraffic serves the requests which are complete, a partial request stays buffered until the rest arrives.
+func (srv *Server) OnTraffic(c uringnet.Conn) uringnet.Action {
+	sc, ok := c.Context().(*serverConn)
+	if !ok {
+		return uringnet.Close
+	}
+	for {
+		buf, _ := c.Peek(-1)
+		n, done, err := sc.parse(buf)
+		if err != nil {
+			return sc.fail(c, err)
+		}
+		if !done {
+			if n > 0 {
+				_, _ = c.Discard(n)
+			}
+			if sc.req.expectContinue && !sc.continued {
+				sc.continued = true
+				_, _ = c.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+			}
+			return uringnet.Echo
+		}
+		// the body may refer to the buffered bytes, which are only discarded after the request is served.
+		keepAlive := srv.serve(c, sc)
+		_, _ = c.Discard(n)
+		if !keepAlive {
+			return uringnet.EchoAndClose
+		}
+		sc.reset()
+		sc.continued = false
+	}
+}
+
+// serve calls the handler for the parsed request and reports whether the connection is kept alive.
+func (srv *Server) serve(c uringnet.Conn, sc *serverConn) (keepAlive bool) {
+	r := &sc.req
+	r.RemoteAddr = c.RemoteAddr().String()
+	if state, ok := c.ConnectionState(); ok {
+		r.TLS = &state
+	}
+	w := &sc.w
+	*w = response{c: c, req: r, header: make(Header), body: w.body[:0], buf: w.buf}
+	w.close = r.Close || !c.RingNet().KeepAlivesEnabled()
+	defer func() {
+		if err := recover(); err != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			logf(c.RingNet(), "uringnet/http: panic serving %v: %v\n%s", r.RemoteAddr, err, buf)
+			keepAlive = false
+		}
+	}()
+	srv.Handler.ServeHTTP(w, r)
+	w.finish()
+	return !w.close
+}
+
+// fail answers a request which can't be served with the status of err, the connection is closed then.
+func (sc *serverConn) fail(c uringnet.Conn, err error) uringnet.Action {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return uringnet.Close
+	}
+	body := fmt.Sprintf("%d %s: %s", se.status, nethttp.StatusText(se.status), se.text)
+	_, _ = fmt.Fprintf(c, "HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\n"+
+		"Content-Length: %d\r\n\r\n%s", se.status, nethttp.StatusText(se.status), len(body), body)
+	return uringnet.EchoAndClose
+}
+
+// logf logs by the ErrorLog of the ring, or by the standard logger if it has none.
+func logf(eng *uringnet.URingNet, format string, args ...interface{}) {
+	if eng.ErrorLog != nil {
+		eng.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,253 @@
+//go:build linux
+// +build linux
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/y001j/uringnet"
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
+)
+
+// startServer runs a ring serving handler, every ring is passed to setup before it runs.
+func startServer(t *testing.T, handler Handler, setup ...func(*uringnet.URingNet)) string {
+	t.Helper()
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}
+	ringNets, err := uringnet.NewMany(uringnet.NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 64, false, 1, options, NewServer(handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ringNet := range ringNets {
+		for _, f := range setup {
+			f(ringNet)
+		}
+	}
+	loop := uringnet.SetLoops(ringNets, 64)
+	if loop == nil {
+		t.Fatal("failed to set the ring loops")
+	}
+	sa, err := unix.Getsockname(ringNets[0].SocketFd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.RunMany()
+	return socket.SockaddrToTCPOrUnixAddr(sa).String()
+}
+
+func dial(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	c, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c, bufio.NewReader(c)
+}
+
+// readResponse reads a response of method and returns it along with its body.
+func readResponse(t *testing.T, br *bufio.Reader, method string) (*nethttp.Response, string) {
+	t.Helper()
+	resp, err := nethttp.ReadResponse(br, &nethttp.Request{Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// echoHandler answers with the method, the path, the body and the trailer of the request.
+var echoHandler = HandlerFunc(func(w ResponseWriter, r *Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, r.Body)
+	if v := r.Trailer.Get("Checksum"); v != "" {
+		_, _ = fmt.Fprintf(w, " %s", v)
+	}
+})
+
+func TestServeKeepAliveAndPipelining(t *testing.T) {
+	addr := startServer(t, echoHandler)
+	c, br := dial(t, addr)
+	defer c.Close()
+
+	// the requests are pipelined in one write, the last one is split in the middle of its header.
+	_, _ = c.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+		"HEAD /c HTTP/1.1\r\nHo"))
+	time.Sleep(10 * time.Millisecond)
+	_, _ = c.Write([]byte("st: x\r\n\r\n"))
+
+	for _, want := range []struct{ method, body string }{{"GET", "GET /a "}, {"POST", "POST /b hello"}, {"HEAD", ""}} {
+		resp, body := readResponse(t, br, want.method)
+		if resp.StatusCode != 200 || resp.Close || body != want.body {
+			t.Fatalf("expect a kept-alive 200 with %q, but got %d %v %q", want.body, resp.StatusCode, resp.Close, body)
+		}
+	}
+
+	// the connection stays open for the next request.
+	_, _ = c.Write([]byte("GET /d HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	resp, body := readResponse(t, br, "GET")
+	if !resp.Close || body != "GET /d " {
+		t.Fatalf("expect the connection to be closed after %q, but got %v %q", "GET /d ", resp.Close, body)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expect EOF, but got %v", err)
+	}
+}
+
+func TestServeChunked(t *testing.T) {
+	addr := startServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/stream" {
+			_, _ = w.Write([]byte("first,"))
+			w.(Flusher).Flush()
+			_, _ = w.Write([]byte("second"))
+			return
+		}
+		echoHandler(w, r)
+	}))
+	c, br := dial(t, addr)
+	defer c.Close()
+
+	// the chunks arrive in pieces, a chunk size and its extension included.
+	for _, part := range []string{
+		"POST /up HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+		"lo\r\n6;ext=1\r",
+		"\n world\r\n0\r\nChecksum: 42\r\n",
+		"\r\n",
+	} {
+		_, _ = c.Write([]byte(part))
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, body := readResponse(t, br, "POST")
+	if resp.StatusCode != 200 || body != "POST /up hello world 42" {
+		t.Fatalf("expect the decoded body, but got %d %q", resp.StatusCode, body)
+	}
+
+	_, _ = c.Write([]byte("GET /stream HTTP/1.1\r\nHost: x\r\n\r\n"))
+	resp, body = readResponse(t, br, "GET")
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" || body != "first,second" {
+		t.Fatalf("expect a chunked %q, but got %v %q", "first,second", resp.TransferEncoding, body)
+	}
+}
+
+func TestServeExpectContinue(t *testing.T) {
+	addr := startServer(t, echoHandler)
+	c, br := dial(t, addr)
+	defer c.Close()
+
+	_, _ = c.Write([]byte("POST /e HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\n"))
+	if resp, _ := readResponse(t, br, "POST"); resp.StatusCode != nethttp.StatusContinue {
+		t.Fatalf("expect %d, but got %d", nethttp.StatusContinue, resp.StatusCode)
+	}
+	_, _ = c.Write([]byte("ok"))
+	if resp, body := readResponse(t, br, "POST"); resp.StatusCode != 200 || body != "POST /e ok" {
+		t.Fatalf("expect 200 %q, but got %d %q", "POST /e ok", resp.StatusCode, body)
+	}
+}
+
+func TestServeMaxHeaderBytes(t *testing.T) {
+	addr := startServer(t, echoHandler, func(ringNet *uringnet.URingNet) {
+		ringNet.MaxHeaderBytes = 256
+	})
+	c, br := dial(t, addr)
+	defer c.Close()
+
+	_, _ = c.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nX-Long: " + strings.Repeat("a", 300)))
+	resp, _ := readResponse(t, br, "GET")
+	if resp.StatusCode != nethttp.StatusRequestHeaderFieldsTooLarge || !resp.Close {
+		t.Fatalf("expect %d and close, but got %d %v", nethttp.StatusRequestHeaderFieldsTooLarge, resp.StatusCode, resp.Close)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expect EOF, but got %v", err)
+	}
+}
+
+func TestServeKeepAlivesDisabled(t *testing.T) {
+	addr := startServer(t, echoHandler, func(ringNet *uringnet.URingNet) {
+		ringNet.SetKeepAlivesEnabled(false)
+	})
+	c, br := dial(t, addr)
+	defer c.Close()
+
+	_, _ = c.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n"))
+	resp, body := readResponse(t, br, "GET")
+	if !resp.Close || body != "GET /a " {
+		t.Fatalf("expect the connection to be closed after %q, but got %v %q", "GET /a ", resp.Close, body)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expect EOF, but got %v", err)
+	}
+}
+
+func TestServeClient(t *testing.T) {
+	addr := startServer(t, echoHandler)
+	client := &nethttp.Client{Timeout: 5 * time.Second}
+	defer client.CloseIdleConnections()
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post("http://"+addr+"/post", "text/plain", bytes.NewReader([]byte("body")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != 200 || string(body) != "POST /post body" {
+			t.Fatalf("expect 200 %q, but got %d %q", "POST /post body", resp.StatusCode, body)
+		}
+	}
+}
+
+func TestParser(t *testing.T) {
+	req := "PUT /x?q=1 HTTP/1.0\r\nHost: h\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\nabc"
+	p := &parser{maxHeaderBytes: DefaultMaxHeaderBytes, maxBodyBytes: DefaultMaxBodyBytes}
+	// the bytes arrive one by one, the ones the parser has taken are discarded like the server does.
+	var buf []byte
+	for i := 0; i < len(req); i++ {
+		buf = append(buf, req[i])
+		n, done, err := p.parse(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done != (i == len(req)-1) {
+			t.Fatalf("expect the request to be complete at byte %d only, but got %v at byte %d", len(req)-1, done, i)
+		}
+		if done {
+			r := &p.req
+			if r.Method != "PUT" || r.URL.RawQuery != "q=1" || r.ProtoMinor != 0 || !r.Close || string(r.Body) != "abc" {
+				t.Fatalf("unexpected request %+v", r)
+			}
+		}
+		buf = buf[n:]
+	}
+
+	for _, tc := range []struct {
+		req    string
+		status int
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", nethttp.StatusBadRequest},
+		{"GET / HTTP/2.0\r\nHost: h\r\n\r\n", nethttp.StatusHTTPVersionNotSupported},
+		{"GET / HTTP/1.1\r\nHost: h\r\n folded\r\n\r\n", nethttp.StatusBadRequest},
+		{"POST / HTTP/1.1\r\nHost: h\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n", nethttp.StatusBadRequest},
+		{"POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: gzip\r\n\r\n", nethttp.StatusNotImplemented},
+		{"POST / HTTP/1.1\r\nHost: h\r\nContent-Length: 9\r\n\r\n", nethttp.StatusRequestEntityTooLarge},
+		{"POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\nz\r\n", nethttp.StatusBadRequest},
+	} {
+		p := &parser{maxHeaderBytes: DefaultMaxHeaderBytes, maxBodyBytes: 8}
+		_, _, err := p.parse([]byte(tc.req))
+		if se, ok := err.(*statusError); !ok || se.status != tc.status {
+			t.Fatalf("expect %d for %q, but got %v", tc.status, tc.req, err)
+		}
+	}
+}
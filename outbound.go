@@ -0,0 +1,174 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"io"
+	"io/fs"
+)
+
+// the minimum capacity of a byte slice allocated for the outbound queue.
+const minOutboundSize = 4096
+
+// zeroCopyThreshold is the size from which the head of the queue is sent without copying it into the kernel,
+// smaller sends are cheaper to copy than to pin until the kernel notifies they are done.
+const zeroCopyThreshold = 16 * 1024
+
+// maxSendIovecs is the maximum number of byte slices of the queue gathered by one send.
+const maxSendIovecs = 64
+
+// outboundQueue keeps the bytes which are eligible to be sent to the peer in the order they were written.
+//
+// The kernel reads the head of the queue while a send is in flight, so bytes which have been queued are never
+// modified: later writes are either appended behind them or go to a new byte slice.
+type outboundQueue struct {
+	bufs     [][]byte       // pending byte slices, the first one may have been sent partially
+	size     int            // total number of pending bytes, including the ones of the files
+	inflight bool           // a send of the head is submitted and not completed yet
+	files    []*fileSegment // ranges of files queued in between the bytes
+	written  int64          // number of bytes ever written to bufs
+	consumed int64          // number of bytes ever sent from bufs
+}
+
+// fileSegment is a range of a file which is queued behind the bytes written before it.
+type fileSegment struct {
+	f         fs.File // keeps the file open while its bytes are sent
+	fd        int
+	offset    int64 // offset of the first byte which hasn't been sent yet
+	remaining int64 // number of bytes which haven't been sent yet
+	at        int64 // number of bytes written to the queue before the file
+}
+
+// Len returns the number of bytes which haven't been sent yet.
+func (q *outboundQueue) Len() int {
+	return q.size
+}
+
+// Write copies p to the tail of the queue.
+func (q *outboundQueue) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if n := len(q.bufs); n > 0 && cap(q.bufs[n-1])-len(q.bufs[n-1]) >= len(p) {
+		// appending within the capacity leaves the bytes in flight untouched.
+		q.bufs[n-1] = append(q.bufs[n-1], p...)
+	} else {
+		size := len(p)
+		if size < minOutboundSize {
+			size = minOutboundSize
+		}
+		q.bufs = append(q.bufs, append(make([]byte, 0, size), p...))
+	}
+	q.size += len(p)
+	q.written += int64(len(p))
+	return len(p), nil
+}
+
+// WriteMessage copies the byte slices to a byte slice of their own at the tail of the queue, which keeps the
+// boundaries of datagrams.
+func (q *outboundQueue) WriteMessage(bs ...[]byte) (n int, err error) {
+	for _, b := range bs {
+		n += len(b)
+	}
+	buf := make([]byte, 0, n)
+	for _, b := range bs {
+		buf = append(buf, b...)
+	}
+	q.bufs = append(q.bufs, buf)
+	q.size += n
+	q.written += int64(n)
+	return n, nil
+}
+
+// ReadFrom reads data from r until EOF and appends it to the queue.
+func (q *outboundQueue) ReadFrom(r io.Reader) (n int64, err error) {
+	buf := make([]byte, minOutboundSize)
+	for {
+		m, e := r.Read(buf)
+		if m > 0 {
+			_, _ = q.Write(buf[:m])
+			n += int64(m)
+		}
+		if e == io.EOF {
+			return n, nil
+		}
+		if e != nil {
+			return n, e
+		}
+	}
+}
+
+// WriteFile queues length bytes of the file f, whose descriptor is fd, starting at offset.
+func (q *outboundQueue) WriteFile(f fs.File, fd int, offset, length int64) {
+	q.files = append(q.files, &fileSegment{f: f, fd: fd, offset: offset, remaining: length, at: q.written})
+	q.size += int(length)
+}
+
+// Heads appends up to max byte slices from the head of the queue to bs, which should be submitted by the next
+// send as a gathered write. They stop short of the next file.
+func (q *outboundQueue) Heads(bs [][]byte, max int) [][]byte {
+	limit := int64(q.size)
+	if len(q.files) > 0 {
+		limit = q.files[0].at - q.consumed
+	}
+	for _, b := range q.bufs {
+		if len(bs) == max || limit == 0 {
+			break
+		}
+		if int64(len(b)) > limit {
+			b = b[:limit]
+		}
+		bs = append(bs, b)
+		limit -= int64(len(b))
+	}
+	return bs
+}
+
+// HeadFile returns the file which should be sent next, nil if bytes come first.
+func (q *outboundQueue) HeadFile() *fileSegment {
+	if len(q.files) > 0 && q.files[0].at == q.consumed {
+		return q.files[0]
+	}
+	return nil
+}
+
+// AdvanceFile drops n bytes which have been sent from the file at the head of the queue.
+func (q *outboundQueue) AdvanceFile(n int) {
+	f := q.files[0]
+	q.size -= n
+	f.offset += int64(n)
+	if f.remaining -= int64(n); f.remaining == 0 {
+		q.files[0] = nil
+		q.files = q.files[1:]
+		if len(q.files) == 0 {
+			q.files = nil
+		}
+	}
+}
+
+// Advance drops n bytes which have been sent from the head of the queue.
+func (q *outboundQueue) Advance(n int) {
+	q.size -= n
+	q.consumed += int64(n)
+	for n > 0 && len(q.bufs) > 0 {
+		if n < len(q.bufs[0]) {
+			q.bufs[0] = q.bufs[0][n:]
+			return
+		}
+		n -= len(q.bufs[0])
+		q.bufs[0] = nil
+		q.bufs = q.bufs[1:]
+	}
+	if len(q.bufs) == 0 {
+		q.bufs = nil
+	}
+}
+
+// Reset drops all the pending bytes.
+func (q *outboundQueue) Reset() {
+	q.bufs = nil
+	q.files = nil
+	q.size = 0
+	q.consumed = q.written
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import "github.com/y001j/uringnet/uring"
+
+// features records which of the optional io_uring operations the kernel of a ring supports.
+type features struct {
+	multishotAccept bool // an accept stays armed for more than one connection
+	multishotRecv   bool // a receive stays armed for more than one read
+	sendZC          bool // sends don't copy the bytes into the kernel
+}
+
+// probe learns the features of the ring, a kernel which can't be probed is assumed to support none of them.
+func (ringNet *URingNet) probe() {
+	var probe uring.Probe
+	if err := ringNet.ring.RegisterProbe(&probe); err != nil {
+		return
+	}
+	// flags of an operation can't be probed, multishot accept arrived in Linux 5.19 along with IORING_OP_SOCKET.
+	ringNet.features.multishotAccept = probe.IsSupported(uring.IORING_OP_SOCKET)
+	// multishot receive arrived in Linux 6.0 along with IORING_OP_SEND_ZC.
+	ringNet.features.multishotRecv = probe.IsSupported(uring.IORING_OP_SEND_ZC)
+	ringNet.features.sendZC = probe.IsSupported(uring.IORING_OP_SEND_ZC)
+}
@@ -4,13 +4,20 @@ package uringnet
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"github.com/y001j/uringnet/errors"
+	socket "github.com/y001j/uringnet/sockets"
 	"github.com/y001j/uringnet/uring"
 
 	"golang.org/x/sys/unix"
 	"io"
+	"io/fs"
 	"net"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -25,7 +32,12 @@ type Ringloop struct {
 	buffer      [][bufLength]byte // read packet buffer whose capacity is set by user, default value is 64KB
 	RingCount   int32             // number of active connections in event-loop
 	udpSockets  map[int]*conn     // client-side UDP socket map: fd -> conn
+	mu          sync.Mutex        // guards udpSockets
+	next        uint32            // the ring which the next socket is handed over to in turn, accessed atomically
 	connections sync.Map          // map[int]*conn // TCP connection map: fd -> conn
+	stopping    int32             // the rings are requested to stop, accessed atomically
+	done        chan struct{}     // closed when every ring has stopped and OnShutdown has returned
+	acceptor    *URingNet         // ring which accepts the connections for the other rings, see RunManyAcceptor
 	//eventHandler EventHandler  // user eventHandler
 }
 
@@ -39,14 +51,18 @@ const bufLength uint64 = 2048
 //	@return *Ringloop
 func SetLoops(urings []*URingNet, bufferSize int) *Ringloop {
 	size := len(urings)
-	theloop := &Ringloop{}
+	theloop := &Ringloop{done: make(chan struct{}), udpSockets: make(map[int]*conn)}
 	theloop.RingCount = int32(size)
 	//theloop.connections = map[int]*conn{}
 	theloop.RingNet = urings
 	for i := 0; i < size; i++ {
 
 		urings[i].ringloop = theloop
+		urings[i].gid = uint16(i)
 		theloop.RingNet[i] = urings[i]
+		if err := urings[i].setupWakeup(); err != nil {
+			return nil
+		}
 		theloop.socketFd = urings[i].SocketFd
 
 		fdstack := make([]int32, 0, 1024)
@@ -57,14 +73,16 @@ func SetLoops(urings []*URingNet, bufferSize int) *Ringloop {
 		}
 
 		//set buffer
-		sqe2 := theloop.RingNet[i].ring.GetSQEntry()
+		// a registered ring of buffers is recycled without SQEs, older kernels fall back to providing them by SQEs.
+		if urings[i].bufRing, err = uring.NewBufferRing(&urings[i].ring, uint16(i), bufferSize, int(bufLength)); err == nil {
+			continue
+		}
 		urings[i].Autobuffer = make([][bufLength]byte, bufferSize)
+		sqe2 := theloop.RingNet[i].ring.GetSQEntry()
 		uring.ProvideBuf(sqe2, urings[i].Autobuffer, uint32(bufferSize), uint32(bufLength), uint16(i))
-		data := makeUserData(provideBuffer)
+		data := urings[i].ops.get(provideBuffer)
 		sqe2.SetUserData(data.id)
-		theloop.RingNet[i].userDataList.Store(data.id, data)
-		fmt.Println("Add Kernel buffer... for ring ", i)
-		_, _ = theloop.RingNet[i].ring.Submit(1, &paraFlags)
+		_, _ = theloop.RingNet[i].ring.Submit(1, &theloop.RingNet[i].enterFlags)
 	}
 	return theloop
 }
@@ -73,53 +91,141 @@ func (loop *Ringloop) GetBuffer() [][bufLength]byte {
 	return loop.buffer
 }
 
+// listen adds the first accept of the listener into SQEs, or the first receive if the engine serves datagrams.
+func (ringNet *URingNet) listen() {
+	if ringNet.isDatagram() {
+		ringNet.recvMsg(nil)
+		return
+	}
+	ringNet.EchoLoop()
+}
+
 // EchoLoop Create an accept event  for the loop.
 // to accept should be set every time when server is initiated.
 func (ringNet *URingNet) EchoLoop() {
 
-	sqe := ringNet.ring.GetSQEntry()
-	data := makeUserData(accepted)
+	sqe := ringNet.getSQE()
+	data := ringNet.ops.get(accepted)
 	// len  := unix.SizeofSockaddrAny
 	length := uint32(unix.SizeofSockaddrAny)
 	data.ClientSock = &syscall.RawSockaddrAny{}
 	data.socklen = &length
 	sqe.SetUserData(data.id)
 	sqe.SetFlags(uring.IOSQE_FIXED_FILE)
+	ringNet.acceptID = data.id
+	ringNet.accepting = true
 
 	//sqe.SetAddr()
 	//fmt.Println(sqe.UserData())
-	ringNet.userDataList.Store(data.id, data)
-	//ringNet.userDataMap[data.id] = data
 	//set client address in data.client
 	//uring.Accept(sqe, uintptr(ringNet.SocketFd), nil, nil)
-	uring.Accept(sqe, uintptr(0), nil, nil)
+	if ringNet.features.multishotAccept {
+		// one accept serves every connection, it is re-armed only once the kernel drops it.
+		uring.AcceptMultishot(sqe, 0)
+	} else {
+		uring.Accept(sqe, uintptr(0), nil, nil)
+	}
 
-	_, err := ringNet.ring.Submit(0, &paraFlags)
+	_, err := ringNet.ring.Submit(0, &ringNet.enterFlags)
 
 	//fmt.Println("echo server running...")
 
 	if err != nil {
-		fmt.Printf("prep request error: %v\n", err)
+		ringNet.logf("uringnet: submit the accept: %v", err)
 		return
 	}
 }
 
+// RunMany starts every ring of the loop on its own goroutine by Run2: the rings read into a buffer of each
+// connection and don't use the buffers provided to the kernel in SetLoops.
 func (loop *Ringloop) RunMany() {
 
 	for i := 0; i < int(loop.RingCount); i++ {
-		loop.RingNet[i].EchoLoop()
+		loop.RingNet[i].listen()
+		atomic.StoreInt32(&loop.RingNet[i].started, 1)
 		go loop.RingNet[i].Run2(uint16(i))
 	}
 }
 
+// RunMany2 is like RunMany, but the rings are started by Run: the reads pick their buffers from the group
+// provided in SetLoops, which is a registered buffer ring if the kernel supports it, and a connection is
+// received from by a multishot receive then. Mind that RunMany2 pairs with Run, and RunMany with Run2.
 func (loop *Ringloop) RunMany2() {
 
 	for i := 0; i < int(loop.RingCount); i++ {
-		loop.RingNet[i].EchoLoop()
+		loop.RingNet[i].listen()
+		atomic.StoreInt32(&loop.RingNet[i].started, 1)
 		go loop.RingNet[i].Run(uint16(i))
 	}
 }
 
+// Shutdown gracefully shuts the loop down: every ring stops accepting, closes its connections once their
+// pending bytes are sent, reporting errors.ErrEngineShutdown to OnClose for the idle ones, and leaves its
+// running cycle. The listener is closed and OnShutdown fires once all the rings have stopped.
+//
+// Shutdown waits for all of that unless ctx is done first, ctx's error is returned then and the rings carry on
+// draining in the background. errors.ErrEngineInShutdown is returned when the loop is shutting down already.
+func (loop *Ringloop) Shutdown(ctx context.Context) error {
+	if !loop.stop() {
+		return errors.ErrEngineInShutdown
+	}
+	select {
+	case <-loop.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop begins the shutdown of every ring of the loop, it reports false when the loop is stopping already.
+// Only the rings which are running are waited for. The acceptor ring stops first, so that no connection is
+// handed over to a ring which has stopped.
+func (loop *Ringloop) stop() bool {
+	if !atomic.CompareAndSwapInt32(&loop.stopping, 0, 1) {
+		return false
+	}
+	for _, ringNet := range loop.RingNet {
+		ringNet.mu.Lock()
+		for _, f := range ringNet.onShutdown {
+			go f()
+		}
+		ringNet.mu.Unlock()
+	}
+	if loop.acceptor == nil {
+		loop.shutdownRings()
+	} else {
+		_ = loop.acceptor.trigger(loop.acceptor.shutdown)
+	}
+	go func() {
+		if loop.acceptor != nil {
+			<-loop.acceptor.done
+			loop.shutdownRings()
+		}
+		for _, ringNet := range loop.RingNet {
+			if atomic.LoadInt32(&ringNet.started) == 1 {
+				<-ringNet.done
+			}
+		}
+		// the rings may keep a reference to the listener for a while, the shutdown stops listening right away.
+		_ = unix.Shutdown(loop.socketFd, unix.SHUT_RDWR)
+		_ = unix.Close(loop.socketFd)
+		if ringNet := loop.RingNet[0]; ringNet.Type == socket.Unix && !strings.HasPrefix(ringNet.Addr, "@") {
+			// like net.UnixListener, the socket file is removed with the listener.
+			_ = os.Remove(ringNet.Addr)
+		}
+		loop.RingNet[0].Handler.OnShutdown(loop.RingNet[0])
+		close(loop.done)
+	}()
+	return true
+}
+
+// shutdownRings begins the shutdown of every ring which serves connections.
+func (loop *Ringloop) shutdownRings() {
+	for _, ringNet := range loop.RingNet {
+		_ = ringNet.trigger(ringNet.shutdown)
+	}
+}
+
 // Action is an action that occurs after the completion of an event.
 type Action int
 
@@ -130,7 +236,8 @@ const (
 	Read
 	EchoAndClose // response then close
 	Write
-	Close //Close the connection.
+	Close    //Close the connection.
+	Shutdown // Shutdown the engine.
 )
 
 // Reader is an interface that consists of a number of methods for reading that Conn must implement.
@@ -142,8 +249,7 @@ type Reader interface {
 
 	// Next returns a slice containing the next n bytes from the buffer,
 	// advancing the buffer as if the bytes had been returned by Read.
-	// If there are fewer than n bytes in the buffer, Next returns the entire buffer.
-	// The error is ErrBufferFull if n is larger than b's buffer size.
+	// If there are fewer than n bytes in the buffer, or n <= 0, Next returns the entire buffer.
 	//
 	// Note that the []byte buf returned by Next() is not allowed to be passed to a new goroutine,
 	// as this []byte will be reused within event-loop.
@@ -189,6 +295,11 @@ type Writer interface {
 	// OutboundBuffered returns the number of bytes that can be read from the current buffer.
 	OutboundBuffered() (n int)
 
+	// SendFile queues length bytes of f starting at offset behind the bytes written so far, a file which has a
+	// descriptor is spliced to the peer without copying it to user space. length <= 0 stands for the rest of
+	// the file, you must call it in the current goroutine.
+	SendFile(f fs.File, offset, length int64) (err error)
+
 	// ==================================== Concurrency-safe API's ====================================
 
 	// AsyncWrite writes one byte slice to peer asynchronously, usually you would call it in individual goroutines
@@ -201,9 +312,10 @@ type Writer interface {
 }
 
 // AsyncCallback is a callback which will be invoked after the asynchronous functions has finished executing.
+// err is net.ErrClosed if the connection had been closed before the function could run, nothing is done then.
 //
 // Note that the parameter gnet.Conn is already released under UDP protocol, thus it's not allowed to be accessed.
-type AsyncCallback func(c Conn) error
+type AsyncCallback func(c Conn, err error) error
 
 // Socket is a set of functions which manipulate the underlying file descriptor of a connection.
 type Socket interface {
@@ -250,6 +362,10 @@ type Socket interface {
 	// algorithm).
 	// The default is true (no delay), meaning that data is sent as soon as possible after a Write.
 	SetNoDelay(noDelay bool) error
+
+	// PeerCred returns the credentials of the process on the other side of a Unix socket, which are
+	// taken from SO_PEERCRED when it connected.
+	PeerCred() (*unix.Ucred, error)
 	// CloseRead() error
 	// CloseWrite() error
 }
@@ -262,10 +378,10 @@ type Conn interface {
 
 	// ================================== Non-concurrency-safe API's ==================================
 
-	// Context returns a user-defined context.
+	// Context returns the user-defined context of the connection.
 	Context() (ctx interface{})
 
-	// SetContext sets a user-defined context.
+	// SetContext attaches a user-defined context to the connection, it lives from OnOpen until OnClose returns.
 	SetContext(ctx interface{})
 
 	// LocalAddr is the connection's local socket address.
@@ -283,6 +399,17 @@ type Conn interface {
 	// SetWriteDeadline implements net.Conn.
 	SetWriteDeadline(t time.Time) (err error)
 
+	// ConnectionState returns the state of the TLS connection, such as the negotiated ALPN protocol and the
+	// certificates of the peer. ok is false if the connection doesn't use TLS, see URingNet.TLSConfig.
+	ConnectionState() (state tls.ConnectionState, ok bool)
+
+	// RingNet returns the ring which drives the connection, its configuration applies to the connection.
+	RingNet() *URingNet
+
+	// SetCodec attaches codec to the connection in place of the codec of the ring, see Codec.
+	// nil stops framing, the bytes which are buffered are handed to OnTraffic as they are after the next read.
+	SetCodec(codec Codec)
+
 	// ==================================== Concurrency-safe API's ====================================
 
 	// Wake triggers a OnTraffic event for the connection.
@@ -300,6 +427,7 @@ type (
 	EventHandler interface {
 		// OnBoot fires when the engine is ready for accepting connections.
 		// The parameter engine has information and various utilities.
+		// It fires on every ring, returning Shutdown shuts the engine down.
 		OnBoot(eng *URingNet) (action Action)
 
 		// OnShutdown fires when the engine is being shut down, it is called right after
@@ -312,11 +440,16 @@ type (
 		// It is usually not recommended to send large amounts of data back to the peer in OnOpened.
 		//
 		// Note that the bytes returned by OnOpened will be sent back to the peer without being encoded.
-		OnOpen(data *UserData) (out []byte, action Action)
+		// The connection starts reading when action is None, Close rejects the connection without
+		// sending out.
+		OnOpen(c Conn) (out []byte, action Action)
 
 		// OnClose fires when a connection has been closed.
-		// The parameter err is the last known connection error.
-		OnClose(data UserData) (action Action)
+		// The parameter err is the reason: nil when the connection is closed by an action or Conn.Close,
+		// io.EOF when the peer has closed it, errors.ErrTimeout or errors.ErrEngineShutdown when the engine
+		// has closed it, and an *os.SyscallError such as ECONNRESET or EPIPE when the connection is broken.
+		// Returning Shutdown shuts the engine down.
+		OnClose(c Conn, err error) (action Action)
 
 		// OnTraffic fires when a socket receives data from the peer.
 		//
@@ -324,28 +457,23 @@ type (
 		// as this []byte will be reused within event-loop after React() returns.
 		// If you have to use packet in a new goroutine, then you need to make a copy of buf and pass this copy
 		// to that new goroutine.
-		OnTraffic(data *UserData, eng *URingNet) (action Action)
+		OnTraffic(c Conn) (action Action)
 
 		// OnTick fires immediately after the engine starts and will fire again
 		// following the duration specified by the delay return value.
+		// It fires on every ring when SocketOptions.Ticker is set, returning Shutdown shuts the engine down.
 		OnTick() (delay time.Duration, action Action)
 
 		// OnWritten fires immediately after the Written/Response completed
-		OnWritten(data UserData) (action Action)
-
-		// Context returns a user-defined context.
-		Context() (ctx interface{})
-
-		// SetContext sets a user-defined context.
-		SetContext(ctx interface{})
+		// The action is carried out like the one returned by OnTraffic, e.g. Read reads the next request
+		// after a Write.
+		OnWritten(c Conn) (action Action)
 	}
 
 	// BuiltinEventEngine is a built-in implementation of EventHandler which sets up each method with a default implementation,
 	// you can compose it with your own implementation of EventHandler when you don't want to implement all methods
 	// in EventHandler.
-	BuiltinEventEngine struct {
-		ctx interface{}
-	}
+	BuiltinEventEngine struct{}
 )
 
 // OnBoot fires when the engine is ready for accepting connections.
@@ -361,18 +489,18 @@ func (es *BuiltinEventEngine) OnShutdown(_ *URingNet) {
 
 // OnOpen fires when a new connection has been opened.
 // The parameter out is the return value which is going to be sent back to the peer.
-func (es *BuiltinEventEngine) OnOpen(_ *UserData) (out []byte, action Action) {
+func (es *BuiltinEventEngine) OnOpen(_ Conn) (out []byte, action Action) {
 	return
 }
 
 // OnClose fires when a connection has been closed.
-// The parameter err is the last known connection error.
-func (es *BuiltinEventEngine) OnClose(_ UserData) (action Action) {
+// The parameter err is the reason why it is closed.
+func (es *BuiltinEventEngine) OnClose(_ Conn, _ error) (action Action) {
 	return
 }
 
 // OnTraffic fires when a local socket receives data from the peer.
-func (es *BuiltinEventEngine) OnTraffic(_ *UserData, _ *URingNet) (action Action) {
+func (es *BuiltinEventEngine) OnTraffic(_ Conn) (action Action) {
 	return
 }
 
@@ -383,18 +511,6 @@ func (es *BuiltinEventEngine) OnTick() (delay time.Duration, action Action) {
 }
 
 // OnWritten fires immediately after the Written/Response completed
-func (es *BuiltinEventEngine) OnWritten(_ UserData) (action Action) {
+func (es *BuiltinEventEngine) OnWritten(_ Conn) (action Action) {
 	return
 }
-
-func (es *BuiltinEventEngine) Context() (ctx interface{}) {
-
-	return es.ctx
-
-}
-
-func (es *BuiltinEventEngine) SetContext(ctx interface{}) {
-
-	es.ctx = ctx
-
-}
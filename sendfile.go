@@ -0,0 +1,171 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// SendFile queues length bytes of f starting at offset behind the bytes written so far, they are sent when the
+// action returned by the current callback is carried out, or when Flush is called, and OnWritten fires once
+// everything is sent. length <= 0 stands for the rest of the file.
+//
+// A file which has a descriptor, e.g. an *os.File, is spliced to the socket through a pipe and never copied to
+// user space, it must stay open until OnWritten fires, even over TLS once the kernel encrypts the records. Other
+// files are read into the outbound buffer right away.
+func (c *conn) SendFile(f fs.File, offset, length int64) error {
+	if c.isDatagram {
+		return errors.ErrUnsupportedOp
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if offset < 0 || offset > size {
+		return os.NewSyscallError("sendfile", unix.EINVAL)
+	}
+	// the range is cut at the end of the file, the pipe would wait for the missing bytes forever.
+	if length <= 0 || length > size-offset {
+		length = size - offset
+	}
+	if length == 0 {
+		return nil
+	}
+	if fd, ok := f.(interface{ Fd() uintptr }); ok && !c.encrypts() {
+		c.outboundBuffer.WriteFile(f, int(fd.Fd()), offset, length)
+		return nil
+	}
+
+	var r io.Reader
+	switch rf := f.(type) {
+	case io.ReaderAt:
+		r = io.NewSectionReader(rf, offset, length)
+	case io.Seeker:
+		if _, err = rf.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		r = io.LimitReader(f, length)
+	default:
+		if _, err = io.CopyN(io.Discard, f, offset); err != nil {
+			return err
+		}
+		r = io.LimitReader(f, length)
+	}
+	// the file is sent as it is, even if the connection has a codec.
+	_, err = c.readFrom(r)
+	return err
+}
+
+// spliceFile adds the splices of the next bytes of f into SQEs: the file is spliced into the pipe of the
+// connection, which is hard-linked to the splice of the pipe to the socket. Bytes left in the pipe by a short
+// splice to the socket are sent first.
+func (ringNet *URingNet) spliceFile(c *conn, f *fileSegment, timeout time.Duration) {
+	if c.pipeSize == 0 {
+		if err := unix.Pipe2(c.pipe[:], unix.O_CLOEXEC); err != nil {
+			ringNet.close(c, os.NewSyscallError("pipe2", err))
+			return
+		}
+		if c.pipeSize, _ = unix.FcntlInt(uintptr(c.pipe[1]), unix.F_GETPIPE_SZ, 0); c.pipeSize <= 0 {
+			c.pipeSize = 1 << 16
+		}
+	}
+	c.outboundBuffer.inflight = true
+	// the splices and the timeout are linked, they must not be split by a submit.
+	ringNet.reserve(maxLinkedSQEs)
+	n := c.piped
+	if n == 0 {
+		n = c.pipeSize
+		if f.remaining < int64(n) {
+			n = int(f.remaining)
+		}
+		data := ringNet.ops.get(spliceIn)
+		data.conn = c
+		sqe := ringNet.ring.GetSQEntry()
+		sqe.SetUserData(data.id)
+		uring.Splice(sqe, int32(f.fd), f.offset, int32(c.pipe[1]), -1, uint32(n), 0)
+		// a short splice breaks a plain link, a failed one closes the pipe, which completes the linked splice.
+		sqe.SetFlags(uring.IOSQE_IO_HARDLINK)
+	}
+	ringNet.spliceOut(c, n, false, timeout)
+}
+
+// spliceOut adds the splice of up to n bytes of the pipe to the socket into SQEs. The socket is non-blocking,
+// so a splice which found it full is preceded by a poll which waits until it is writable. The poll starts
+// the chain, spliceFile has reserved the SQEs otherwise.
+func (ringNet *URingNet) spliceOut(c *conn, n int, poll bool, timeout time.Duration) {
+	if poll {
+		sqe := ringNet.getSQE()
+		uring.PollAdd(sqe, uintptr(c.fd), unix.POLLOUT)
+		sqe.SetFlags(uring.IOSQE_IO_LINK)
+	}
+	data := ringNet.ops.get(spliceOut)
+	data.conn = c
+	sqe := ringNet.ring.GetSQEntry()
+	sqe.SetUserData(data.id)
+	uring.Splice(sqe, int32(c.pipe[0]), -1, int32(c.fd), -1, uint32(n), 0)
+	if timeout > 0 {
+		ringNet.linkTimeout(sqe, data, timeout)
+	}
+}
+
+// splicedIn handles the splice of a file into the pipe, the splice of the pipe to the socket follows it.
+func (ringNet *URingNet) splicedIn(c *conn, res int32) {
+	if !c.opened {
+		return
+	}
+	if res <= 0 {
+		err := io.ErrUnexpectedEOF
+		if res < 0 {
+			err = os.NewSyscallError("splice", unix.Errno(-res))
+		}
+		ringNet.close(c, err)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		return
+	}
+	c.piped += int(res)
+}
+
+// splicedOut handles the splice of the pipe to the socket.
+func (ringNet *URingNet) splicedOut(c *conn, res int32, timed bool) {
+	c.outboundBuffer.inflight = false
+	if !c.opened {
+		return
+	}
+	if res == -int32(unix.EAGAIN) {
+		timeout := ringNet.writeTimeout(c)
+		if timeout < 0 {
+			ringNet.close(c, errors.ErrTimeout)
+		} else {
+			c.outboundBuffer.inflight = true
+			ringNet.spliceOut(c, c.piped, true, timeout)
+		}
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		return
+	}
+	if res <= 0 {
+		ringNet.close(c, resultError("splice", res, timed))
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		return
+	}
+	c.piped -= int(res)
+	c.outboundBuffer.AdvanceFile(int(res))
+	ringNet.written(c)
+}
+
+// closePipe releases the pipe of the connection, splices in flight keep their own references to it.
+func (c *conn) closePipe() {
+	if c.pipeSize > 0 {
+		_ = unix.Close(c.pipe[0])
+		_ = unix.Close(c.pipe[1])
+		c.pipeSize, c.piped = 0, 0
+	}
+}
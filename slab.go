@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+// opSlab keeps the records of the operations a ring has in flight. The user data of an SQE packs the
+// generation of its record in the high 32 bits and the record's position in the slab, starting from 1,
+// in the low 32 bits, so that a completion finds its record by indexing and a completion which refers to
+// a record released meanwhile is detected and dropped.
+//
+// The records are reused rather than allocated for every operation and never move, the kernel may keep
+// pointers to their fields. A slab is only used by the goroutine of its ring. User data 0 never refers to
+// a record, it marks the SQEs whose completions don't need one.
+type opSlab struct {
+	records []*UserData
+	free    []uint32 // positions of the released records
+}
+
+// get returns a record for a new operation in the given state.
+func (s *opSlab) get(state UserdataState) *UserData {
+	var pos uint32
+	if n := len(s.free); n > 0 {
+		pos = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		s.records = append(s.records, new(UserData))
+		pos = uint32(len(s.records))
+	}
+	data := s.records[pos-1]
+	data.id = uint64(data.gen)<<32 | uint64(pos)
+	data.state = uint32(state)
+	return data
+}
+
+// lookup returns the record which the user data of a completion refers to, or nil if there is no such
+// record any more.
+func (s *opSlab) lookup(id uint64) *UserData {
+	pos := uint32(id)
+	if pos == 0 || int(pos) > len(s.records) {
+		return nil
+	}
+	if data := s.records[pos-1]; data.id == id {
+		return data
+	}
+	return nil
+}
+
+// put releases the record, the generation is bumped so that the user data handed out so far doesn't
+// refer to it any more.
+func (s *opSlab) put(data *UserData) {
+	pos := uint32(data.id)
+	*data = UserData{gen: data.gen + 1}
+	s.free = append(s.free, pos)
+}
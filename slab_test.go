@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import "testing"
+
+func TestOpSlab(t *testing.T) {
+	var s opSlab
+	a := s.get(prepareReader)
+	b := s.get(PrepareWriter)
+	if a.id == 0 || b.id == 0 || a.id == b.id {
+		t.Fatalf("expect distinct non-zero user data, but got %#x and %#x", a.id, b.id)
+	}
+	if s.lookup(a.id) != a || s.lookup(b.id) != b {
+		t.Fatal("the records are not found by their user data")
+	}
+	if s.lookup(0) != nil {
+		t.Fatal("user data 0 must not refer to a record")
+	}
+
+	stale := a.id
+	s.put(a)
+	if s.lookup(stale) != nil {
+		t.Fatal("a released record is found")
+	}
+	c := s.get(closed)
+	if c != a {
+		t.Fatal("the released record is not reused")
+	}
+	if c.id == stale || s.lookup(stale) != nil {
+		t.Fatal("the completion of the former operation refers to the reused record")
+	}
+	if c.state != uint32(closed) || c.conn != nil {
+		t.Fatal("the reused record is not reset")
+	}
+	if len(s.records) != 2 {
+		t.Fatalf("expect 2 records, but got %d", len(s.records))
+	}
+}
@@ -0,0 +1,144 @@
+//go:build linux
+// +build linux
+
+package socket
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/y001j/uringnet/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Options of the SOL_TLS level and values of the crypto info, see linux/tls.h.
+const (
+	tlsTX = 1
+	tlsRX = 2
+
+	tlsCipherAESGCM128        = 51
+	tlsCipherAESGCM256        = 52
+	tlsCipherChaCha20Poly1305 = 54
+)
+
+// KTLSCryptoInfo is the state of one direction of a TLS 1.3 session which the kernel takes over.
+type KTLSCryptoInfo struct {
+	CipherSuite uint16 // one of the TLS 1.3 cipher suites of crypto/tls
+	Key         []byte // traffic key
+	IV          []byte // traffic IV, 12 bytes
+	Seq         uint64 // sequence number of the next record
+}
+
+// tlsCryptoInfo mirrors struct tls_crypto_info, which the crypto info of every cipher starts with.
+type tlsCryptoInfo struct {
+	version    uint16
+	cipherType uint16
+}
+
+type tlsCryptoInfoAESGCM128 struct {
+	info   tlsCryptoInfo
+	iv     [8]byte
+	key    [16]byte
+	salt   [4]byte
+	recSeq [8]byte
+}
+
+type tlsCryptoInfoAESGCM256 struct {
+	info   tlsCryptoInfo
+	iv     [8]byte
+	key    [32]byte
+	salt   [4]byte
+	recSeq [8]byte
+}
+
+type tlsCryptoInfoChaCha20Poly1305 struct {
+	info   tlsCryptoInfo
+	iv     [12]byte
+	key    [32]byte
+	recSeq [8]byte
+}
+
+var (
+	ktlsOnce      sync.Once
+	ktlsSupported bool
+)
+
+// KTLSSupported reports whether the kernel has the TLS module, it is loaded by the probe if it is available but
+// hasn't been loaded yet. The kernel is only probed by the first call.
+func KTLSSupported() bool {
+	ktlsOnce.Do(func() {
+		fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			return
+		}
+		defer unix.Close(fd)
+		// the module is looked up before the socket is checked, an unconnected socket is refused with ENOTCONN.
+		err = unix.SetsockoptString(fd, unix.SOL_TCP, unix.TCP_ULP, "tls")
+		ktlsSupported = err != unix.ENOENT
+	})
+	return ktlsSupported
+}
+
+// EnableKTLS attaches the TLS upper layer protocol to the established TCP socket fd, which keeps working as a
+// plain socket until SetKTLSTx or SetKTLSRx is called. An error satisfying os.IsNotExist means that the kernel
+// has no TLS module.
+func EnableKTLS(fd int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, unix.SOL_TCP, unix.TCP_ULP, "tls"))
+}
+
+// SetKTLSTx hands the sending direction of the session over to the kernel, which encrypts the bytes sent
+// from now on.
+func SetKTLSTx(fd int, info *KTLSCryptoInfo) error {
+	return setKTLS(fd, tlsTX, info)
+}
+
+// SetKTLSRx hands the receiving direction of the session over to the kernel, which decrypts the records
+// received from now on. A record which isn't application data fails the receive with EIO.
+func SetKTLSRx(fd int, info *KTLSCryptoInfo) error {
+	return setKTLS(fd, tlsRX, info)
+}
+
+func setKTLS(fd, opt int, info *KTLSCryptoInfo) error {
+	if len(info.IV) != 12 {
+		return errors.ErrUnsupportedOp
+	}
+	var seq [8]byte
+	// the sequence number is kept in network byte order.
+	binary.BigEndian.PutUint64(seq[:], info.Seq)
+	var b []byte
+	switch info.CipherSuite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		ci := tlsCryptoInfoAESGCM128{info: tlsCryptoInfo{tls.VersionTLS13, tlsCipherAESGCM128}, recSeq: seq}
+		if len(info.Key) != len(ci.key) {
+			return errors.ErrUnsupportedOp
+		}
+		// the first 4 bytes of the IV are the salt, the others are the implicit part of the nonce.
+		copy(ci.salt[:], info.IV[:4])
+		copy(ci.iv[:], info.IV[4:])
+		copy(ci.key[:], info.Key)
+		b = (*[unsafe.Sizeof(ci)]byte)(unsafe.Pointer(&ci))[:]
+	case tls.TLS_AES_256_GCM_SHA384:
+		ci := tlsCryptoInfoAESGCM256{info: tlsCryptoInfo{tls.VersionTLS13, tlsCipherAESGCM256}, recSeq: seq}
+		if len(info.Key) != len(ci.key) {
+			return errors.ErrUnsupportedOp
+		}
+		copy(ci.salt[:], info.IV[:4])
+		copy(ci.iv[:], info.IV[4:])
+		copy(ci.key[:], info.Key)
+		b = (*[unsafe.Sizeof(ci)]byte)(unsafe.Pointer(&ci))[:]
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		ci := tlsCryptoInfoChaCha20Poly1305{info: tlsCryptoInfo{tls.VersionTLS13, tlsCipherChaCha20Poly1305}, recSeq: seq}
+		if len(info.Key) != len(ci.key) {
+			return errors.ErrUnsupportedOp
+		}
+		copy(ci.iv[:], info.IV)
+		copy(ci.key[:], info.Key)
+		b = (*[unsafe.Sizeof(ci)]byte)(unsafe.Pointer(&ci))[:]
+	default:
+		return errors.ErrUnsupportedOp
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, unix.SOL_TLS, opt, string(b)))
+}
@@ -23,8 +23,13 @@ package socket
 
 import (
 	"net"
+	"os"
 	"strings"
 	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/y001j/uringnet/errors"
 )
 
 type NetAddressType string
@@ -57,7 +62,26 @@ func UDPSocket(proto, addr string, connect bool, sockOpts ...Option) (int, net.A
 
 // UnixSocket calls the internal udsSocket.
 func UnixSocket(proto, addr string, passive bool, sockOpts ...Option) (int, net.Addr, error) {
-	return udsSocket(proto, addr, passive, sockOpts...)
+	return udsSocket(proto, addr, passive, 0, sockOpts...)
+}
+
+// UnixListenerSocket creates a listening Unix socket whose file has the given mode, the mode is left to the
+// umask if it is 0. A stale socket file left at addr is removed first.
+func UnixListenerSocket(proto, addr string, mode os.FileMode, sockOpts ...Option) (int, net.Addr, error) {
+	return udsSocket(proto, addr, true, mode, sockOpts...)
+}
+
+// ClientSocket creates a non-blocking stream socket for a connection to addr and returns it along with the
+// address it should be connected to, which is left to the caller, e.g. to connect it asynchronously.
+// proto is one of tcp, tcp4, tcp6 and unix.
+func ClientSocket(proto, addr string, sockOpts ...Option) (int, unix.Sockaddr, error) {
+	switch NetAddressType(proto) {
+	case Tcp, Tcp4, Tcp6:
+		return tcpClientSocket(proto, addr, sockOpts...)
+	case Unix:
+		return udsClientSocket(proto, addr, sockOpts...)
+	}
+	return -1, nil, errors.ErrUnsupportedProtocol
 }
 
 // TCPSocketOpt is the type of TCP socket options.
@@ -69,6 +93,19 @@ const (
 	TCPDelay
 )
 
+// LoadBalancing is the algorithm which picks the ring a new connection is assigned to.
+type LoadBalancing int
+
+// Available load-balancing algorithms.
+const (
+	// RoundRobin assigns the connections to the rings in turn.
+	RoundRobin LoadBalancing = iota
+	// LeastConnections assigns a connection to the ring which serves the fewest connections.
+	LeastConnections
+	// SourceAddrHash assigns the connections from the same IP address to the same ring.
+	SourceAddrHash
+)
+
 // Options are configurations for sockets creation.
 type SocketOptions struct {
 	// ================================== Options for only server-side ==================================
@@ -78,7 +115,8 @@ type SocketOptions struct {
 	NumEventLoop int
 
 	// LB represents the load-balancing algorithm used when assigning new connections.
-	//LB LoadBalancing
+	// It applies to the connections accepted by a dedicated acceptor ring, see Ringloop.RunManyAcceptor.
+	LB LoadBalancing
 
 	// ReuseAddr indicates whether to set up the SO_REUSEADDR socket option.
 	ReuseAddr bool
@@ -86,6 +124,9 @@ type SocketOptions struct {
 	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
 	ReusePort bool
 
+	// UnixSocketMode is the file mode of the socket file of a Unix listener, it is left to the umask if it is 0.
+	UnixSocketMode os.FileMode
+
 	// ============================= Options for both server-side and client-side =============================
 
 	// ReadBufferCap is the maximum number of bytes that can be read from the peer when the readable event comes.
@@ -139,15 +139,52 @@ func tcpSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, ne
 		}
 	}
 
+	if !passive {
+		// a client-side socket is bound to an ephemeral port by the connect.
+		err = os.NewSyscallError("connect", unix.Connect(fd, sa))
+		return
+	}
+
 	if err = os.NewSyscallError("bind", unix.Bind(fd, sa)); err != nil {
 		return
 	}
+	// Set backlog size to the maximum.
+	err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
 
-	if passive {
-		// Set backlog size to the maximum.
-		err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
-	} else {
-		err = os.NewSyscallError("connect", unix.Connect(fd, sa))
+	return
+}
+
+// tcpClientSocket creates a socket which is going to connect to addr, it is left to the caller to connect it.
+func tcpClientSocket(proto, addr string, sockOpts ...Option) (fd int, sa unix.Sockaddr, err error) {
+	var (
+		family   int
+		ipv6only bool
+	)
+
+	if sa, family, _, ipv6only, err = GetTCPSockAddr(proto, addr); err != nil {
+		return
+	}
+
+	if fd, err = sysSocket(family, unix.SOCK_STREAM, unix.IPPROTO_TCP); err != nil {
+		err = os.NewSyscallError("socket", err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = unix.Close(fd)
+		}
+	}()
+
+	if family == unix.AF_INET6 && ipv6only {
+		if err = SetIPv6Only(fd, 1); err != nil {
+			return
+		}
+	}
+
+	for _, sockOpt := range sockOpts {
+		if err = sockOpt.SetSockOpt(fd, sockOpt.Opt); err != nil {
+			return
+		}
 	}
 
 	return
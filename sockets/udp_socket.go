@@ -143,10 +143,11 @@ func udpSocket(proto, addr string, connect bool, sockOpts ...Option) (fd int, ne
 		}
 	}
 
-	err = os.NewSyscallError("bind", unix.Bind(fd, sa))
-
 	if connect {
+		// a client-side socket is bound to an ephemeral port by the connect.
 		err = os.NewSyscallError("connect", unix.Connect(fd, sa))
+	} else {
+		err = os.NewSyscallError("bind", unix.Bind(fd, sa))
 	}
 
 	return
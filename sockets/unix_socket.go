@@ -45,7 +45,8 @@ func GetUnixSockAddr(proto, addr string) (sa unix.Sockaddr, family int, unixAddr
 
 // udsSocket creates an endpoint for communication and returns a file descriptor that refers to that endpoint.
 // Argument `reusePort` indicates whether the SO_REUSEPORT flag will be assigned.
-func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, netAddr net.Addr, err error) {
+// A passive socket replaces a stale socket file left at addr, and its file gets the given mode unless it is 0.
+func udsSocket(proto, addr string, passive bool, mode os.FileMode, sockOpts ...Option) (fd int, netAddr net.Addr, err error) {
 	var (
 		family int
 		sa     unix.Sockaddr
@@ -71,16 +72,71 @@ func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, ne
 		}
 	}
 
+	if !passive {
+		err = os.NewSyscallError("connect", unix.Connect(fd, sa))
+		return
+	}
+
+	removeStaleSocket(addr)
 	if err = os.NewSyscallError("bind", unix.Bind(fd, sa)); err != nil {
 		return
 	}
+	if mode != 0 && !isAbstract(addr) {
+		// the mode is set before listening, so no peer can connect under the default permissions.
+		if err = os.Chmod(addr, mode); err != nil {
+			return
+		}
+	}
+	// Set backlog size to the maximum.
+	err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
 
-	if passive {
-		// Set backlog size to the maximum.
-		err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
-	} else {
-		err = os.NewSyscallError("connect", unix.Connect(fd, sa))
+	return
+}
+
+// udsClientSocket creates a socket which is going to connect to addr, it is left to the caller to connect it.
+func udsClientSocket(proto, addr string, sockOpts ...Option) (fd int, sa unix.Sockaddr, err error) {
+	var family int
+
+	if sa, family, _, err = GetUnixSockAddr(proto, addr); err != nil {
+		return
+	}
+
+	if fd, err = sysSocket(family, unix.SOCK_STREAM, 0); err != nil {
+		err = os.NewSyscallError("socket", err)
+		return
+	}
+
+	for _, sockOpt := range sockOpts {
+		if err = sockOpt.SetSockOpt(fd, sockOpt.Opt); err != nil {
+			_ = unix.Close(fd)
+			return
+		}
 	}
 
 	return
 }
+
+// removeStaleSocket removes the socket file at path if nothing listens on it any more, which is the case when
+// the former server didn't remove it on exit. Any other file and a socket which is still served are kept,
+// the bind fails on them.
+func removeStaleSocket(path string) {
+	if isAbstract(path) {
+		return
+	}
+	if fi, err := os.Lstat(path); err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	fd, err := sysSocket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+	if err = unix.Connect(fd, &unix.SockaddrUnix{Name: path}); err == unix.ECONNREFUSED {
+		_ = os.Remove(path)
+	}
+}
+
+// isAbstract reports whether path is in the abstract namespace, which has no file.
+func isAbstract(path string) bool {
+	return len(path) > 0 && path[0] == '@'
+}
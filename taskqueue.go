@@ -0,0 +1,86 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/y001j/uringnet/errors"
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// asyncTaskQueue collects the tasks which other goroutines hand to a ring, they are executed on the ring's
+// own goroutine.
+type asyncTaskQueue struct {
+	mu     sync.Mutex
+	tasks  []func()
+	closed bool // the ring has left its running cycle, no task is accepted any more
+}
+
+// swap takes all the queued tasks, buf is reused as the new queue to avoid allocations.
+func (q *asyncTaskQueue) swap(buf []func()) []func() {
+	q.mu.Lock()
+	tasks := q.tasks
+	q.tasks = buf[:0]
+	q.mu.Unlock()
+	return tasks
+}
+
+// setupWakeup creates the eventfd which is used to wake the ring up from other goroutines.
+func (ringNet *URingNet) setupWakeup() (err error) {
+	ringNet.wakeFd, err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	return
+}
+
+// trigger queues the task and wakes the ring up, the task is executed on the ring's goroutine.
+// It is safe to call trigger from any goroutine, errors.ErrEngineShutdown is returned once the ring has
+// left its running cycle.
+func (ringNet *URingNet) trigger(task func()) error {
+	q := &ringNet.tasks
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errors.ErrEngineShutdown
+	}
+	q.tasks = append(q.tasks, task)
+	// only the first task since the last wakeup writes the eventfd.
+	if atomic.CompareAndSwapInt32(&ringNet.notified, 0, 1) {
+		var one = [8]byte{1}
+		_, _ = unix.Write(ringNet.wakeFd, one[:])
+	}
+	return nil
+}
+
+// closeWakeup drops the queued tasks and releases the eventfd, trigger fails from now on.
+func (ringNet *URingNet) closeWakeup() {
+	q := &ringNet.tasks
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.tasks = nil
+	_ = unix.Close(ringNet.wakeFd)
+}
+
+// armWakeup adds a read event of the eventfd into SQEs, it completes when trigger is called.
+func (ringNet *URingNet) armWakeup() {
+	data := ringNet.ops.get(wakeup)
+	sqe := ringNet.getSQE()
+	sqe.SetUserData(data.id)
+	uring.Read(sqe, uintptr(ringNet.wakeFd), ringNet.wakeBuf[:])
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+}
+
+// runTasks executes all the queued tasks and re-arms the eventfd read.
+func (ringNet *URingNet) runTasks() {
+	atomic.StoreInt32(&ringNet.notified, 0)
+	tasks := ringNet.tasks.swap(ringNet.taskBuf)
+	for i, task := range tasks {
+		task()
+		tasks[i] = nil
+	}
+	ringNet.taskBuf = tasks
+	ringNet.armWakeup()
+}
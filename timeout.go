@@ -0,0 +1,62 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"time"
+
+	"github.com/y001j/uringnet/uring"
+	"golang.org/x/sys/unix"
+)
+
+// readTimeout returns how long the next read of the connection may wait for the peer, zero means it may wait
+// forever and a negative value means the read deadline has been exceeded already.
+//
+// The first read waits for the beginning of a request and is limited by ReadHeaderTimeout, a read which
+// continues a partially consumed request is limited by ReadTimeout, and a read with nothing pending is
+// limited by IdleTimeout. ReadTimeout is used whenever the other two are zero.
+func (ringNet *URingNet) readTimeout(c *conn) time.Duration {
+	d := ringNet.ReadTimeout
+	switch {
+	case !c.received:
+		if ringNet.ReadHeaderTimeout > 0 {
+			d = ringNet.ReadHeaderTimeout
+		}
+	case c.InboundBuffered() == 0:
+		if ringNet.IdleTimeout > 0 {
+			d = ringNet.IdleTimeout
+		}
+	}
+	return untilDeadline(d, c.readDeadline)
+}
+
+// writeTimeout returns how long the next send of the connection may wait, like readTimeout.
+func (ringNet *URingNet) writeTimeout(c *conn) time.Duration {
+	return untilDeadline(ringNet.WriteTimeout, c.writeDeadline)
+}
+
+// untilDeadline shortens the timeout d to the time left until deadline.
+func untilDeadline(d time.Duration, deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return d
+	}
+	left := time.Until(deadline)
+	if left <= 0 {
+		return -1
+	}
+	if d == 0 || left < d {
+		return left
+	}
+	return d
+}
+
+// linkTimeout chains a timeout to the operation whose SQE is sqe, the operation completes with -ECANCELED
+// if it doesn't complete within d. The timespec is kept by the userdata of the operation.
+// The completion of the timeout itself carries no userdata and is dropped by the running cycle.
+func (ringNet *URingNet) linkTimeout(sqe *uring.SQEntry, data *UserData, d time.Duration) {
+	sqe.SetFlags(sqe.GetFlags() | uring.IOSQE_IO_LINK)
+	data.timeout = unix.NsecToTimespec(int64(d))
+	sqe = ringNet.ring.GetSQEntry()
+	uring.LinkTimeout(sqe, &data.timeout, false)
+}
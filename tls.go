@@ -0,0 +1,429 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	socket "github.com/y001j/uringnet/sockets"
+)
+
+// tlsReadSize is the size of the buffer which plaintext is read into, a TLS record carries at most 16KB.
+const tlsReadSize = 16 * 1024
+
+// tlsConn is the TLS layer of a connection. crypto/tls runs the handshake and the record layer in a goroutine
+// of its own over an in-memory transport: the ring feeds the transport with the bytes it receives and sends the
+// bytes which crypto/tls writes to it, the plaintext is handed back to the ring, which fires OnTraffic.
+//
+// The records of a TLS 1.3 session are protected by the kernel instead once the handshake is over, provided
+// that the kernel has the TLS module, see offload.
+type tlsConn struct {
+	conn        *tls.Conn
+	transport   *tlsTransport
+	established bool                // the handshake has completed and the connection is open, only accessed by the ring
+	state       tls.ConnectionState // state of the connection once it is established
+	pending     [][]byte            // plaintext written before the connection is established
+	secrets     *tlsSecrets         // traffic secrets of the session, nil if it isn't meant to be offloaded
+	draining    bool                // the session is offloaded once the bytes of the handshake are sent
+	ktx         bool                // the kernel encrypts the bytes sent
+	krx         bool                // the kernel decrypts the bytes received
+	offloaded   chan bool           // tells the goroutine whether it stops reading, it does if the kernel decrypts
+}
+
+// tlsTransport is the net.Conn which crypto/tls reads the bytes received from and writes the bytes to send to.
+type tlsTransport struct {
+	c          *conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	mu       sync.Mutex
+	cond     sync.Cond
+	in       bytes.Buffer // bytes received which crypto/tls hasn't read yet
+	left     int          // bytes of the current record which crypto/tls hasn't read yet
+	wanted   bool         // a receive is requested from the ring
+	out      []byte       // bytes written by crypto/tls which aren't queued for sending yet
+	eof      bool         // the peer has closed its side
+	closed   bool         // the connection is closed
+	inline   bool         // crypto/tls is writing on the ring's goroutine, which queues the bytes itself
+	flushing bool         // a task which queues the bytes for sending is handed over to the ring
+}
+
+// tlsSecrets keeps the traffic secrets which crypto/tls logs to the KeyLogWriter, the keys protecting the
+// records of a TLS 1.3 session are derived from them.
+type tlsSecrets struct {
+	w      io.Writer // KeyLogWriter of the configuration, nil if there is none
+	client []byte
+	server []byte
+}
+
+// serve opens an accepted connection, the TLS handshake comes first if TLSConfig is set. OnOpen fires once the
+// handshake has completed, so the state of the TLS connection is known by then.
+func (ringNet *URingNet) serve(c *conn) {
+	if ringNet.TLSConfig == nil {
+		ringNet.open(c)
+		return
+	}
+	t := &tlsTransport{c: c, localAddr: c.LocalAddr(), remoteAddr: c.RemoteAddr()}
+	t.cond.L = &t.mu
+	c.tls = &tlsConn{transport: t, offloaded: make(chan bool, 1)}
+	config := ringNet.TLSConfig
+	if (config.MaxVersion == 0 || config.MaxVersion >= tls.VersionTLS13) && socket.KTLSSupported() {
+		base := config
+		config = config.Clone()
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.tls.offloadConfig(base, hello)
+		}
+	}
+	c.tls.conn = tls.Server(t, config)
+	go c.tls.run(ringNet, c)
+	ringNet.read(c)
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+}
+
+// offloadConfig returns the config of a client which offers TLS 1.3, the session may be offloaded then: its
+// traffic secrets are kept and session tickets are disabled, because the kernel has to know the sequence numbers
+// and a ticket would take one which the ring doesn't see. The config of base, or the one its GetConfigForClient
+// returns, is left alone for a client which doesn't offer TLS 1.3, so TLS 1.2 clients still resume sessions.
+func (t *tlsConn) offloadConfig(base *tls.Config, hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	config := base
+	if base.GetConfigForClient != nil {
+		c, err := base.GetConfigForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			config = c
+		}
+	}
+	if config.MaxVersion != 0 && config.MaxVersion < tls.VersionTLS13 {
+		return config, nil
+	}
+	for _, v := range hello.SupportedVersions {
+		if v == tls.VersionTLS13 {
+			t.secrets = &tlsSecrets{w: config.KeyLogWriter}
+			config = config.Clone()
+			config.KeyLogWriter = t.secrets
+			config.SessionTicketsDisabled = true
+			return config, nil
+		}
+	}
+	return config, nil
+}
+
+// run carries out the handshake and then reads the plaintext until the connection is closed, everything the
+// connection learns is handed over to the ring.
+func (t *tlsConn) run(ringNet *URingNet, c *conn) {
+	closeConn := func(err error) {
+		_ = ringNet.trigger(func() {
+			ringNet.close(c, err)
+			_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		})
+	}
+	if err := t.conn.Handshake(); err != nil {
+		closeConn(err)
+		return
+	}
+	state := t.conn.ConnectionState()
+	err := ringNet.trigger(func() {
+		if c.opened {
+			ringNet.handshaken(c, state)
+		}
+	})
+	if err != nil {
+		return
+	}
+	// the goroutine doesn't read until it is known whether the kernel takes over, or the connection is closed.
+	if stop := <-t.offloaded; stop {
+		return
+	}
+	buf := make([]byte, tlsReadSize)
+	for {
+		n, err := t.conn.Read(buf)
+		if n > 0 {
+			b := append([]byte(nil), buf[:n]...)
+			_ = ringNet.trigger(func() {
+				if c.opened {
+					c.feed(b)
+					ringNet.response(c)
+				}
+			})
+		}
+		if err != nil {
+			closeConn(err)
+			return
+		}
+	}
+}
+
+// handshaken establishes the connection once its handshake has completed. A session which is going to be
+// offloaded waits until the bytes of the handshake are sent, the kernel would encrypt them again otherwise.
+func (ringNet *URingNet) handshaken(c *conn, state tls.ConnectionState) {
+	t := c.tls
+	t.state = state
+	if t.secrets != nil && (c.outboundBuffer.Len() > 0 || c.outboundBuffer.inflight) {
+		t.draining = true
+		return
+	}
+	ringNet.establish(c)
+}
+
+// establish decides who protects the records, then the plaintext written meanwhile is written again and OnOpen
+// fires.
+func (ringNet *URingNet) establish(c *conn) {
+	t := c.tls
+	t.draining = false
+	ringNet.offload(c)
+	t.offloaded <- t.krx
+	t.established = true
+	pending := t.pending
+	t.pending = nil
+	for _, p := range pending {
+		_, _ = t.write(c, p)
+	}
+	ringNet.open(c)
+}
+
+// offload hands the session over to the kernel, each direction which the kernel refuses stays in user space.
+// The receiving direction is only handed over if no byte of the session has been received after the handshake.
+func (ringNet *URingNet) offload(c *conn) {
+	t := c.tls
+	if t.secrets == nil || t.state.Version != tls.VersionTLS13 {
+		return
+	}
+	tx, rx, ok := t.secrets.cryptoInfo(t.state.CipherSuite)
+	if !ok {
+		return
+	}
+	if err := socket.EnableKTLS(c.fd); err != nil {
+		return
+	}
+	t.ktx = socket.SetKTLSTx(c.fd, tx) == nil
+	t.transport.mu.Lock()
+	received := t.transport.in.Len() > 0 || t.transport.left > 0
+	t.transport.mu.Unlock()
+	if !received && !c.reading {
+		t.krx = socket.SetKTLSRx(c.fd, rx) == nil
+	}
+}
+
+// encrypts reports whether the bytes written to the connection are encrypted in user space.
+func (c *conn) encrypts() bool {
+	return c.tls != nil && !c.tls.ktx
+}
+
+// write encrypts p into the outbound buffer of the connection, unless the kernel does it.
+func (t *tlsConn) write(c *conn, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !t.established {
+		t.pending = append(t.pending, append([]byte(nil), p...))
+		return len(p), nil
+	}
+	if t.ktx {
+		return c.outboundBuffer.Write(p)
+	}
+	t.transport.mu.Lock()
+	t.transport.inline = true
+	t.transport.mu.Unlock()
+	n, err := t.conn.Write(p)
+	t.transport.drain(&c.outboundBuffer)
+	return n, err
+}
+
+// feed hands the bytes received from the peer over to crypto/tls.
+func (t *tlsTransport) feed(b []byte) {
+	t.mu.Lock()
+	_, _ = t.in.Write(b)
+	t.wanted = false
+	t.mu.Unlock()
+	t.cond.Signal()
+}
+
+// closeRead tells crypto/tls that the peer has closed its side once the bytes received are read.
+func (t *tlsTransport) closeRead() {
+	t.mu.Lock()
+	t.eof = true
+	t.mu.Unlock()
+	t.cond.Signal()
+}
+
+// drain queues the bytes written by crypto/tls for sending, it is only called by the ring.
+func (t *tlsTransport) drain(q *outboundQueue) {
+	t.mu.Lock()
+	_, _ = q.Write(t.out)
+	t.out = t.out[:0]
+	t.inline, t.flushing = false, false
+	t.mu.Unlock()
+}
+
+// Read returns the bytes of one record at most, so that crypto/tls never holds the bytes of a record which
+// follows the handshake, and requests a receive from the ring when there is nothing to read. The ring doesn't
+// receive unless it is requested to, so no receive is in flight when the handshake is over.
+func (t *tlsTransport) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for !t.closed && !t.eof && (t.in.Len() == 0 || t.left == 0 && t.in.Len() < 5) {
+		if !t.wanted {
+			t.wanted = true
+			c, ringNet := t.c, t.c.ringNet
+			_ = ringNet.trigger(func() {
+				if c.opened && !c.tls.krx {
+					ringNet.read(c)
+					_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+				}
+			})
+		}
+		t.cond.Wait()
+	}
+	if t.closed {
+		return 0, net.ErrClosed
+	}
+	if t.in.Len() == 0 {
+		return 0, io.EOF
+	}
+	if b := t.in.Bytes(); t.left == 0 && len(b) >= 5 {
+		// the header of a record ends with the length of its body.
+		t.left = 5 + (int(b[3])<<8 | int(b[4]))
+	}
+	if t.left > 0 && len(p) > t.left {
+		p = p[:t.left]
+	}
+	n, _ := t.in.Read(p)
+	if t.left -= n; t.left < 0 {
+		t.left = 0
+	}
+	return n, nil
+}
+
+// Write keeps p until the ring queues it for sending. The bytes written on another goroutine than the ring's,
+// e.g. those of the handshake, are handed over to the ring by a task.
+func (t *tlsTransport) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	t.out = append(t.out, p...)
+	flush := !t.inline && !t.flushing
+	if flush {
+		t.flushing = true
+	}
+	t.mu.Unlock()
+	if flush {
+		c, ringNet := t.c, t.c.ringNet
+		_ = ringNet.trigger(func() {
+			if !c.opened {
+				return
+			}
+			t.drain(&c.outboundBuffer)
+			ringNet.send(c)
+			_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		})
+	}
+	return len(p), nil
+}
+
+// Close releases crypto/tls from waiting for bytes, it is called by the ring when the connection is closed.
+func (t *tlsTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.cond.Broadcast()
+	return nil
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr {
+	return t.localAddr
+}
+
+func (t *tlsTransport) RemoteAddr() net.Addr {
+	return t.remoteAddr
+}
+
+// SetDeadline does nothing, the deadlines of the connection are enforced by the ring.
+func (t *tlsTransport) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (t *tlsTransport) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (t *tlsTransport) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// Write records the application traffic secrets of a key log line and passes the line on.
+// crypto/tls writes every line at once.
+func (s *tlsSecrets) Write(line []byte) (int, error) {
+	if fields := bytes.Fields(line); len(fields) == 3 {
+		switch string(fields[0]) {
+		case "CLIENT_TRAFFIC_SECRET_0":
+			s.client, _ = hex.DecodeString(string(fields[2]))
+		case "SERVER_TRAFFIC_SECRET_0":
+			s.server, _ = hex.DecodeString(string(fields[2]))
+		}
+	}
+	if s.w != nil {
+		return s.w.Write(line)
+	}
+	return len(line), nil
+}
+
+// cryptoInfo derives the keys which the server sends and receives the records of the session with, see
+// RFC 8446 section 7.3. ok is false if the cipher suite or the secrets are unknown.
+func (s *tlsSecrets) cryptoInfo(suite uint16) (tx, rx *socket.KTLSCryptoInfo, ok bool) {
+	var h func() hash.Hash
+	var keyLen int
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		h, keyLen = sha256.New, 16
+	case tls.TLS_AES_256_GCM_SHA384:
+		h, keyLen = sha512.New384, 32
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		h, keyLen = sha256.New, 32
+	default:
+		return nil, nil, false
+	}
+	if s.client == nil || s.server == nil {
+		return nil, nil, false
+	}
+	derive := func(secret []byte) *socket.KTLSCryptoInfo {
+		return &socket.KTLSCryptoInfo{
+			CipherSuite: suite,
+			Key:         hkdfExpandLabel(h, secret, "key", keyLen),
+			IV:          hkdfExpandLabel(h, secret, "iv", 12),
+		}
+	}
+	return derive(s.server), derive(s.client), true
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label of TLS 1.3 with an empty context.
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := append([]byte{byte(length >> 8), byte(length), byte(len(label))}, label...)
+	info = append(info, 0)
+	mac := hmac.New(h, secret)
+	var out, block []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac.Reset()
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,116 @@
+package uring
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// MaxBufRingEntries is the maximum number of entries of a ring of provided buffers.
+const MaxBufRingEntries = 1 << 15
+
+// BufferRing is a ring of provided buffers registered with a Ring as a buffer group. An operation which selects
+// a buffer of the group takes it from the head of the ring, the buffer is given back by adding it at the tail
+// and advancing the tail, which takes no SQE unlike IORING_OP_PROVIDE_BUFFERS.
+//
+// BufferRing isn't safe for concurrent use, it is meant to be used by the goroutine which reaps the completions.
+type BufferRing struct {
+	ring    *Ring
+	mem     []byte // memory of the entries, it is shared with the kernel
+	entries []Buf  // entries laid over mem
+	mask    uint16 // number of entries - 1
+	tail    uint16 // the tail which is published by the next Advance
+	bgid    uint16 // buffer group id
+	bufs    []byte // memory of the buffers, buffer bid starts at bid*size
+	size    int    // size of a buffer
+}
+
+// NewBufferRing allocates count buffers of size bytes each, registers them as the buffer group bgid of r and
+// adds all of them to the ring. count is at most MaxBufRingEntries. It requires Linux 5.19.
+func NewBufferRing(r *Ring, bgid uint16, count, size int) (*BufferRing, error) {
+	if count <= 0 || count > MaxBufRingEntries || size <= 0 {
+		return nil, unix.EINVAL
+	}
+	n := 1
+	for n < count {
+		n <<= 1
+	}
+	// the entries have to be page aligned, so they aren't allocated by Go.
+	mem, err := unix.Mmap(-1, 0, n*int(unsafe.Sizeof(Buf{})), unix.PROT_READ|unix.PROT_WRITE,
+		unix.MAP_ANONYMOUS|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	br := &BufferRing{
+		ring:    r,
+		mem:     mem,
+		entries: unsafe.Slice((*Buf)(unsafe.Pointer(&mem[0])), n),
+		mask:    uint16(n - 1),
+		bgid:    bgid,
+		bufs:    make([]byte, count*size),
+		size:    size,
+	}
+	reg := BufReg{RingAddr: uint64(uintptr(unsafe.Pointer(&mem[0]))), RingEntries: uint32(n), Bgid: bgid}
+	if err = r.RegisterBufRing(&reg); err != nil {
+		_ = unix.Munmap(mem)
+		return nil, err
+	}
+	for bid := 0; bid < count; bid++ {
+		br.Add(uint16(bid))
+	}
+	br.Advance()
+	return br, nil
+}
+
+// Group returns the buffer group id of the ring.
+func (br *BufferRing) Group() uint16 {
+	return br.bgid
+}
+
+// Count returns the number of buffers.
+func (br *BufferRing) Count() int {
+	return len(br.bufs) / br.size
+}
+
+// Size returns the size of a buffer.
+func (br *BufferRing) Size() int {
+	return br.size
+}
+
+// Buffer returns the buffer bid, it belongs to the kernel from its Add until a completion picks it.
+func (br *BufferRing) Buffer(bid uint16) []byte {
+	off := int(bid) * br.size
+	return br.bufs[off : off+br.size : off+br.size]
+}
+
+// Add writes the entry of the buffer bid at the tail of the ring, the kernel doesn't see it until Advance.
+func (br *BufferRing) Add(bid uint16) {
+	e := &br.entries[br.tail&br.mask]
+	e.Addr = uint64(uintptr(unsafe.Pointer(&br.bufs[int(bid)*br.size])))
+	e.Len = uint32(br.size)
+	e.Bid = bid
+	br.tail++
+}
+
+// Advance publishes the entries added since the last Advance. The tail overlays the reserved field behind the
+// buffer id of the first entry, both are stored by one atomic store which orders the entries before the tail.
+func (br *BufferRing) Advance() {
+	head := &br.entries[0]
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&head.Bid)), uint32(br.tail)<<16|uint32(head.Bid))
+}
+
+// Recycle gives the buffer bid back to the kernel right away.
+func (br *BufferRing) Recycle(bid uint16) {
+	br.Add(bid)
+	br.Advance()
+}
+
+// Close unregisters the ring and releases its memory, none of its buffers may be used any more.
+func (br *BufferRing) Close() error {
+	err := br.ring.UnregisterBufRing(br.bgid)
+	if e := unix.Munmap(br.mem); err == nil {
+		err = e
+	}
+	return err
+}
@@ -53,6 +53,15 @@ const (
 	IORING_OP_MKDIRAT
 	IORING_OP_SYMLINKAT
 	IORING_OP_LINKAT
+	IORING_OP_MSG_RING
+	IORING_OP_FSETXATTR
+	IORING_OP_SETXATTR
+	IORING_OP_FGETXATTR
+	IORING_OP_GETXATTR
+	IORING_OP_SOCKET
+	IORING_OP_URING_CMD
+	IORING_OP_SEND_ZC
+	IORING_OP_SENDMSG_ZC
 	IORING_OP_LAST
 )
 
@@ -75,8 +84,24 @@ const IORING_TIMEOUT_ABS uint32 = 1 << 0
 // sqe splice flags
 const SPLICE_F_FD_IN_FIXED uint32 = 1 << 31
 
+// sqe accept flags, they are passed in ioprio
+const IORING_ACCEPT_MULTISHOT uint16 = 1 << 0
+
+// sqe send and receive flags, they are passed in ioprio
+const (
+	IORING_RECVSEND_POLL_FIRST uint16 = 1 << iota
+	IORING_RECV_MULTISHOT
+	IORING_RECVSEND_FIXED_BUF
+	IORING_SEND_ZC_REPORT_USAGE
+)
+
 // cqe flags
-const IORING_CQE_F_BUFFER uint32 = 1 << 0
+const (
+	IORING_CQE_F_BUFFER uint32 = 1 << iota
+	IORING_CQE_F_MORE
+	IORING_CQE_F_SOCK_NONEMPTY
+	IORING_CQE_F_NOTIF
+)
 
 const IORING_CQE_BUFFER_SHIFT uint32 = 16
 
@@ -327,6 +352,15 @@ func (e CQEntry) UserData() uint64 {
 	return e.userData
 }
 
+// BufferID returns the id of the buffer which the operation picked from its buffer group,
+// ok is false if no buffer was picked.
+func (e CQEntry) BufferID() (bid uint16, ok bool) {
+	if e.flags&IORING_CQE_F_BUFFER == 0 {
+		return 0, false
+	}
+	return uint16(e.flags >> IORING_CQE_BUFFER_SHIFT), true
+}
+
 type Sigset_t struct {
 	Val [16]uint64
 }
@@ -106,6 +106,13 @@ func Close(sqe *SQEntry, fd uintptr) {
 	sqe.fd = int32(fd)
 }
 
+// Shutdown ...
+func Shutdown(sqe *SQEntry, fd uintptr, how uint32) {
+	sqe.SetOpcode(IORING_OP_SHUTDOWN)
+	sqe.SetFD(int32(fd))
+	sqe.SetLen(how)
+}
+
 // Send ...
 func Send(sqe *SQEntry, fd uintptr, buf []byte, flags uint32) {
 	sqe.SetOpcode(IORING_OP_SEND)
@@ -115,6 +122,19 @@ func Send(sqe *SQEntry, fd uintptr, buf []byte, flags uint32) {
 	sqe.SetOpcodeFlags(flags)
 }
 
+// SendZC sends buf without copying it into the kernel. The operation completes twice with the same user data:
+// the first CQE carries the result and IORING_CQE_F_MORE, the second one carries IORING_CQE_F_NOTIF once the
+// kernel doesn't reference buf any more, buf must stay untouched until then. zcFlags are IORING_RECVSEND_*
+// and IORING_SEND_ZC_* flags. It requires Linux 6.0.
+func SendZC(sqe *SQEntry, fd uintptr, buf []byte, flags uint32, zcFlags uint16) {
+	sqe.SetOpcode(IORING_OP_SEND_ZC)
+	sqe.SetFD(int32(fd))
+	sqe.SetAddr((uint64)(uintptr(unsafe.Pointer(&buf[0]))))
+	sqe.SetLen(uint32(len(buf)))
+	sqe.SetOpcodeFlags(flags)
+	sqe.SetIOPrio(zcFlags)
+}
+
 // Recv ...
 func Recv(sqe *SQEntry, fd uintptr, buf []byte, flags uint32) {
 	sqe.SetOpcode(IORING_OP_RECV)
@@ -124,6 +144,83 @@ func Recv(sqe *SQEntry, fd uintptr, buf []byte, flags uint32) {
 	sqe.SetOpcodeFlags(flags)
 }
 
+// RecvMultishot keeps receiving from fd with a single SQE, every completion carries a buffer selected from the
+// group set by SetBufGroup, which requires IOSQE_BUFFER_SELECT. The receive stays armed as long as the CQE
+// carries IORING_CQE_F_MORE. It requires Linux 6.0.
+func RecvMultishot(sqe *SQEntry, fd uintptr, flags uint32) {
+	sqe.SetOpcode(IORING_OP_RECV)
+	sqe.SetFD(int32(fd))
+	sqe.SetOpcodeFlags(flags)
+	sqe.SetIOPrio(IORING_RECV_MULTISHOT)
+}
+
+// SendMsg sends the message described by msg, the message and everything it points to must stay
+// untouched until the operation completes.
+func SendMsg(sqe *SQEntry, fd uintptr, msg *unix.Msghdr, flags uint32) {
+	sqe.SetOpcode(IORING_OP_SENDMSG)
+	sqe.SetFD(int32(fd))
+	sqe.SetAddr((uint64)(uintptr(unsafe.Pointer(msg))))
+	sqe.SetLen(1)
+	sqe.SetOpcodeFlags(flags)
+}
+
+// RecvMsg receives a message into msg. With IOSQE_BUFFER_SELECT msg must have a single iovec whose base
+// is nil and whose length is the size of the buffers in the group.
+func RecvMsg(sqe *SQEntry, fd uintptr, msg *unix.Msghdr, flags uint32) {
+	sqe.SetOpcode(IORING_OP_RECVMSG)
+	sqe.SetFD(int32(fd))
+	sqe.SetAddr((uint64)(uintptr(unsafe.Pointer(msg))))
+	sqe.SetLen(1)
+	sqe.SetOpcodeFlags(flags)
+}
+
+// Connect connects the socket fd to the address addr of length addrLen, the address must stay untouched
+// until the operation completes.
+func Connect(sqe *SQEntry, fd uintptr, addr *unix.RawSockaddrAny, addrLen uint32) {
+	sqe.SetOpcode(IORING_OP_CONNECT)
+	sqe.SetFD(int32(fd))
+	sqe.SetAddr((uint64)(uintptr(unsafe.Pointer(addr))))
+	sqe.SetOffset(uint64(addrLen))
+}
+
+// Splice moves up to n bytes from fdIn to fdOut, one of them has to be a pipe. offIn and offOut are the offsets
+// in the files, -1 stands for a pipe or the current position of the file. With SPLICE_F_FD_IN_FIXED in flags
+// fdIn is an index of the registered files, IOSQE_FIXED_FILE does the same for fdOut.
+func Splice(sqe *SQEntry, fdIn int32, offIn int64, fdOut int32, offOut int64, n uint32, flags uint32) {
+	sqe.SetOpcode(IORING_OP_SPLICE)
+	sqe.SetFD(fdOut)
+	sqe.SetOffset(uint64(offOut))
+	sqe.SetSpliceFdIn(fdIn)
+	sqe.SetSpliceOffIn(uint64(offIn))
+	sqe.SetLen(n)
+	sqe.SetOpcodeFlags(flags)
+}
+
+// Tee duplicates up to n bytes from the pipe fdIn to the pipe fdOut without consuming them.
+// With SPLICE_F_FD_IN_FIXED in flags fdIn is an index of the registered files.
+func Tee(sqe *SQEntry, fdIn, fdOut int32, n uint32, flags uint32) {
+	sqe.SetOpcode(IORING_OP_TEE)
+	sqe.SetFD(fdOut)
+	sqe.SetSpliceFdIn(fdIn)
+	sqe.SetLen(n)
+	sqe.SetOpcodeFlags(flags)
+}
+
+// PollAdd completes once fd is ready for one of the events in mask, e.g. unix.POLLOUT.
+func PollAdd(sqe *SQEntry, fd uintptr, mask uint32) {
+	sqe.SetOpcode(IORING_OP_POLL_ADD)
+	sqe.SetFD(int32(fd))
+	sqe.SetOpcodeFlags(mask)
+}
+
+// AsyncCancel cancels the operation whose user data is userData, it completes with -ENOENT
+// if the operation can't be found.
+func AsyncCancel(sqe *SQEntry, userData uint64) {
+	sqe.SetFD(-1)
+	sqe.SetOpcode(IORING_OP_ASYNC_CANCEL)
+	sqe.SetAddr(userData)
+}
+
 // Timeout operation.
 // if abs is true then IORING_TIMEOUT_ABS will be added to timeoutFlags.
 // count is the number of events to wait.
@@ -170,6 +267,14 @@ func Accept(sqe *SQEntry, fd uintptr, clientAddr *syscall.RawSockaddrAny, len *u
 	sqe.SetOffset(uint64(uintptr(unsafe.Pointer(&len))))
 }
 
+// AcceptMultishot keeps accepting connections on fd with a single SQE. Every connection completes with a CQE,
+// the accept stays armed as long as the CQE carries IORING_CQE_F_MORE. It requires Linux 5.19.
+func AcceptMultishot(sqe *SQEntry, fd uintptr) {
+	sqe.SetOpcode(IORING_OP_ACCEPT)
+	sqe.SetFD(int32(fd))
+	sqe.SetIOPrio(IORING_ACCEPT_MULTISHOT)
+}
+
 func ProvideBuf(sqe *SQEntry, clientAddr [][BufferSize]byte, bufferCount uint32, bufferSize uint32, gid uint16) {
 
 	sqe.SetOpcode(IORING_OP_PROVIDE_BUFFERS)
@@ -17,6 +17,19 @@ const (
 	IORING_REGISTER_PROBE
 	IORING_REGISTER_PERSONALITY
 	IORING_UNREGISTER_PERSONALITY
+	IORING_REGISTER_RESTRICTIONS
+	IORING_REGISTER_ENABLE_RINGS
+	IORING_REGISTER_FILES2
+	IORING_REGISTER_FILES_UPDATE2
+	IORING_REGISTER_BUFFERS2
+	IORING_REGISTER_BUFFERS_UPDATE
+	IORING_REGISTER_IOWQ_AFF
+	IORING_UNREGISTER_IOWQ_AFF
+	IORING_REGISTER_IOWQ_MAX_WORKERS
+	IORING_REGISTER_RING_FDS
+	IORING_UNREGISTER_RING_FDS
+	IORING_REGISTER_PBUF_RING
+	IORING_UNREGISTER_PBUF_RING
 )
 
 const (
@@ -176,6 +189,63 @@ func (r *Ring) UnregisterBuffers() error {
 	}
 }
 
+// BufReg describes a ring of provided buffers, see RegisterBufRing.
+type BufReg struct {
+	RingAddr    uint64 // page aligned address of the ring of Buf entries
+	RingEntries uint32 // number of entries, a power of 2 up to 32768
+	Bgid        uint16 // buffer group id
+	pad         uint16
+	resv        [3]uint64
+}
+
+// Buf is an entry of a ring of provided buffers. The tail of the ring overlays the resv field of its first entry.
+type Buf struct {
+	Addr uint64
+	Len  uint32
+	Bid  uint16
+	resv uint16
+}
+
+// RegisterBufRing registers a ring of provided buffers, reads which select a buffer of the group pick it from
+// the ring instead of the buffers provided by IORING_OP_PROVIDE_BUFFERS. It requires Linux 5.19.
+func (r *Ring) RegisterBufRing(reg *BufReg) error {
+	for {
+		_, _, errno := unix.Syscall6(
+			IO_URING_REGISTER,
+			uintptr(r.fd),
+			IORING_REGISTER_PBUF_RING,
+			uintptr(unsafe.Pointer(reg)),
+			1, 0, 0)
+		if errno > 0 {
+			if errno == unix.EINTR {
+				continue
+			}
+			return errno
+		}
+		return nil
+	}
+}
+
+// UnregisterBufRing unregisters the ring of provided buffers of the group bgid.
+func (r *Ring) UnregisterBufRing(bgid uint16) error {
+	reg := BufReg{Bgid: bgid}
+	for {
+		_, _, errno := unix.Syscall6(
+			IO_URING_REGISTER,
+			uintptr(r.fd),
+			IORING_UNREGISTER_PBUF_RING,
+			uintptr(unsafe.Pointer(&reg)),
+			1, 0, 0)
+		if errno > 0 {
+			if errno == unix.EINTR {
+				continue
+			}
+			return errno
+		}
+		return nil
+	}
+}
+
 // SetupEventfd creates eventfd and registers it with current uring instance.
 func (r *Ring) SetupEventfd() error {
 	if r.eventfd == 0 {
@@ -126,6 +126,11 @@ func (r *Ring) GetSQEntry() *SQEntry {
 	return nil
 }
 
+// SQSpaceLeft returns the number of SQEs which GetSQEntry is able to return before the SQ is submitted.
+func (r *Ring) SQSpaceLeft() uint32 {
+	return *r.sq.ringEntries - (r.sq.sqeTail - atomic.LoadUint32(r.sq.head))
+}
+
 // Flush submission queue.
 func (r *Ring) Flush() uint32 {
 	toSubmit := r.sq.sqeTail - r.sq.sqeHead
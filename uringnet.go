@@ -4,12 +4,15 @@
 package uringnet
 
 import (
+	"context"
 	"crypto/tls"
-	"fmt"
+	"github.com/y001j/uringnet/errors"
 	socket "github.com/y001j/uringnet/sockets"
 	"github.com/y001j/uringnet/uring"
 	"golang.org/x/sys/unix"
+	"io"
 	"log"
+	"os"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -18,15 +21,19 @@ import (
 )
 
 type URingNet struct {
-	Addr              string                // TCP address to listen on, ":http" if empty
-	Type              socket.NetAddressType //the connection type
-	SocketFd          int                   //listener socket fd
-	Handler           EventHandler          // It is used to handle the network event.
-	TLSConfig         *tls.Config           // optional TLS config, to support TLS is under development
-	ReadTimeout       time.Duration         // maximum duration before timing out read of the request, it will be used to set the socket option
-	ReadHeaderTimeout time.Duration
-	WriteTimeout      time.Duration
-	IdleTimeout       time.Duration
+	Addr     string                // TCP address to listen on, ":http" if empty
+	Type     socket.NetAddressType //the connection type
+	SocketFd int                   //listener socket fd
+	Handler  EventHandler          // It is used to handle the network event.
+	// TLSConfig is the optional TLS config, the accepted connections are served over TLS if it is set. A TLS 1.3
+	// session is offloaded to kernel TLS if the kernel has the TLS module. Session tickets are disabled for the
+	// clients which offer TLS 1.3 then, so those can't resume their sessions, whereas TLS 1.2 clients still can.
+	TLSConfig         *tls.Config
+	Codec             Codec         // optional codec of the stream connections, OnTraffic receives whole frames if it is set
+	ReadTimeout       time.Duration // maximum duration a read may wait for the rest of a request, it is also the default of the two below
+	ReadHeaderTimeout time.Duration // maximum duration the first read of a connection may wait
+	WriteTimeout      time.Duration // maximum duration a send may wait for the peer to take the bytes
+	IdleTimeout       time.Duration // maximum duration a read may wait when nothing is pending
 	MaxHeaderBytes    int
 	Fd                atomic.Uintptr
 	//TLSNextProto      map[string]func(*URingNet, *tls.Conn, Handler)
@@ -34,19 +41,45 @@ type URingNet struct {
 	ErrorLog *log.Logger
 
 	disableKeepAlives int32 // accessed atomically.
-	inShutdown        int32
+	inShutdown        int32 // the ring is draining its connections, accessed atomically
+	started           int32 // the running cycle of the ring has been started, accessed atomically
 	Count             uint32
 	nextProtoOnce     sync.Once
 	nextProtoErr      error
 	ring              uring.Ring
-	userDataList      sync.Map // all the userdata
-	userDataMap       map[uint64]*UserData
-	ReadBuffer        []byte
+	ops               opSlab // records of the operations in flight
+	enterFlags        uint32 // flags of io_uring_enter, they are updated by the submissions of the ring
+	ReadBuffer        []byte // Deprecated: every connection reads into its own buffer, use the Reader methods of Conn instead.
 	WriteBuffer       []byte
 
 	Autobuffer [][bufLength]byte // it is just prepared for auto buffer of io_uring
 
-	ringloop *Ringloop
+	ringloop   *Ringloop
+	gid        uint16 // buffer group id of the ring, it is also the index of the ring in the loop
+	autoBuffer bool   // reads pick buffers from the kernel buffer group
+
+	tasks    asyncTaskQueue // tasks handed over by other goroutines
+	taskBuf  []func()       // spare task slice which is swapped with the queue
+	wakeFd   int            // eventfd which wakes the ring up when a task is queued
+	wakeBuf  [8]byte        // buffer which the eventfd counter is read into
+	notified int32          // the eventfd has been written since the last wakeup, accessed atomically
+
+	ticker bool          // OnTick is fired by the ring
+	tickTs unix.Timespec // delay of the pending tick
+	done   chan struct{} // closed when the ring has left its running cycle
+
+	acceptID  uint64           // userdata id of the pending accept, or the pending receive of a datagram socket
+	accepting bool             // an accept, or a receive of a datagram socket, is in flight
+	closes    int              // number of closes in flight
+	msgs      []*message       // spare messages for datagram operations
+	dials     map[*conn]uint64 // userdata ids of the connects in flight
+	notifs    int              // number of zero-copy sends whose notification is pending
+
+	features features          // optional operations supported by the kernel
+	bufRing  *uring.BufferRing // ring of the provided buffers, nil if they are provided by SQEs
+
+	lb      socket.LoadBalancing // algorithm which picks the ring a connection accepted by the acceptor ring goes to
+	pending uint32               // connections handed over by the acceptor ring and not opened yet, accessed atomically
 
 	mu sync.Mutex
 	//listeners map[*net.Listener]struct{}
@@ -72,10 +105,18 @@ const (
 	PrepareWriter                      // 2. network write is completed
 	closed                             // 3. the socket is closed.
 	provideBuffer                      // 4. buffer has been created.
+	wakeup                             // 5. the ring is woken up by another goroutine.
+	ticking                            // 6. the delay returned by OnTick is elapsed.
+	readMessage                        // 7. a datagram is received.
+	writeMessage                       // 8. a datagram is sent.
+	connecting                         // 9. a socket created by Dial is connected.
+	spliceIn                           // 10. a range of a file is spliced into the pipe of the connection.
+	spliceOut                          // 11. the pipe of the connection is spliced to the socket.
 )
 
 type UserData struct {
-	id uint64
+	id  uint64 // user data of the SQE, see opSlab
+	gen uint32 // generation of the record, it is bumped whenever the record is released
 
 	//resulter chan<- Result
 	opcode uint8
@@ -94,6 +135,11 @@ type UserData struct {
 	ClientSock *syscall.RawSockaddrAny
 	socklen    *uint32
 
+	conn    *conn         // the connection which the event belongs to
+	msg     *message      // the message of a datagram operation
+	timeout unix.Timespec // timeout linked to the operation
+	err     error         // the reason why the connection is closed
+
 	//Bytebuffer bytes.Buffer
 
 	//r0 interface{}
@@ -113,62 +159,62 @@ func (data *UserData) SetState(state UserdataState) {
 	atomic.StoreUint32(&data.state, uint32(state))
 }
 
+// timed reports whether a timeout is linked to the operation.
+func (data *UserData) timed() bool {
+	return data.timeout != unix.Timespec{}
+}
+
 type request struct {
 	ringNet URingNet
 	done    chan struct{}
 }
 
-var increase uint64 = 1
-
-func makeUserData(state UserdataState) *UserData {
-	defer func() {
-		err := recover() // 内置函数，可以捕获异常
-		if err != nil {
-			fmt.Println("err:", err)
-			fmt.Println("发生异常............")
-		}
-	}()
-
-	userData := new(UserData)
-	//userData := &UserData{
-	//	//ringNet: ringNet,
-	//	state: uint32(state),
-	//}
-	userData.state = uint32(state)
-	userData.id = increase
-	increase++
-
-	return userData
-}
-
 // SetUring creates an IO_Uring instance
 func (ringNet *URingNet) SetUring(size uint, params *uring.IOUringParams) (ring *uring.Ring, err error) {
 	thering, err := uring.Setup(size, params)
+	if err != nil {
+		return nil, err
+	}
 	ringNet.ring = *thering
+	ringNet.probe()
 	return thering, err
 }
 
-var paraFlags uint32
-
-// Run2 is the core running cycle of io_uring, this function don't use auto buffer.
+// Run2 is the core running cycle of io_uring, this function don't use auto buffer: every connection reads
+// into a buffer of its own. RunMany runs the rings by Run2.
 // TODO: Still don't have the best formula to get buffer size and SQE size.
 func (ringNet *URingNet) Run2(ringing uint16) {
+	ringNet.run(ringing, false)
+}
+
+// Run is the core running cycle of io_uring, this function will use auto buffer: the reads pick their buffers
+// from the group provided in SetLoops, the buffer ring included. RunMany2 runs the rings by Run.
+func (ringNet *URingNet) Run(ringing uint16) {
+	ringNet.run(ringing, true)
+}
+
+// run reaps the completion queue of the ring and dispatches every completion to the connection it belongs to.
+// autoBuffer decides whether reads pick a kernel buffer from the group provided in SetLoops.
+func (ringNet *URingNet) run(ringing uint16, autoBuffer bool) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
-	ringNet.Handler.OnBoot(ringNet)
-	//var connect_num uint32 = 0
+	defer close(ringNet.done)
+	// the ring may be run without RunMany, the loop waits for it when it shuts down then.
+	atomic.StoreInt32(&ringNet.started, 1)
+	ringNet.gid = ringing
+	ringNet.autoBuffer = autoBuffer
+	ringNet.armWakeup()
+	if ringNet.Handler.OnBoot(ringNet) == Shutdown {
+		ringNet.ringloop.stop()
+	}
+	if ringNet.ticker {
+		ringNet.tick()
+	}
 	for {
+		if atomic.LoadInt32(&ringNet.inShutdown) == 1 && ringNet.drained() {
+			break
+		}
 		cqe, err := ringNet.ring.GetCQEntry(1)
-
-		//defer ringnet.ring.Close()
-		// have accepted
-		//theFd := ringnet.Fd.Load()
-		//if theFd != 0 {
-		//	sqe := ringnet.ring.GetSQEntry()
-		//	ringnet.read(int32(theFd), sqe, ringindex)
-		//	ringnet.Fd.Store(0)
-		//}
-
 		if err != nil {
 			if err == unix.EAGAIN {
 				//log.Println("Completion queue is empty!")
@@ -178,405 +224,636 @@ func (ringNet *URingNet) Run2(ringing uint16) {
 			continue
 		}
 
-		data, suc := ringNet.userDataList.Load(cqe.UserData())
-
-		//data, suc := ringnet.userDataMap[cqe.UserData()]
-		if !suc {
-			//log.Println("Cannot find matched userdata!")
-			//ringnet.ring.Flush()
+		thedata := ringNet.ops.lookup(cqe.UserData())
+		if thedata == nil {
+			// the SQE needs no record, or the completion is stale.
 			continue
 		}
+		// the record is released before the completion is handled, the handlers are going to reuse it.
+		// A multishot operation keeps its record as long as more completions are coming.
+		state, c, closeErr, msg, timed := thedata.state, thedata.conn, thedata.err, thedata.msg, thedata.timed()
+		if cqe.Flags()&uring.IORING_CQE_F_MORE == 0 {
+			ringNet.ops.put(thedata)
+		}
 
-		thedata := (data).(*UserData)
-
-		//ioc := unix.Iovec{}
-		//ioc.SetLen(1)
-		switch thedata.state {
+		switch state {
 		case uint32(provideBuffer):
-			ringNet.userDataList.Delete(thedata.id)
 			continue
+		case uint32(wakeup):
+			ringNet.runTasks()
+		case uint32(ticking):
+			ringNet.tick()
+		case uint32(readMessage):
+			ringNet.receive(c, msg, cqe)
+			ringNet.putMessage(msg)
+		case uint32(writeMessage):
+			ringNet.putMessage(msg)
+			ringNet.sent(c, cqe.Result())
+		case uint32(connecting):
+			ringNet.connected(c, cqe.Result(), timed)
 		case uint32(accepted):
-			ringNet.Handler.OnOpen(thedata)
-			ringNet.EchoLoop()
-			Fd := cqe.Result()
-			//connect_num++
-			//log.Printf("URing Number: %d Client Conn %d: \n", ringindex, connect_num)
-			//log.Println("URing Number: ", ringindex, " Client Conn %d:", connect_num)
-
-			sqe := ringNet.ring.GetSQEntry()
-			//claim buffer for read
-			//buffer := make([]byte, 1024) //ringnet.BufferPool.Get().(*[]byte)
-			//temp := ringnet.BufferPool.Get()
-			//bb := temp.(*[]byte)
-			//ringNet.read2(Fd, sqe)
-			ringNet.recv(Fd, sqe, ringing)
-
-			//ringnet.read(Fd, sqe, ringindex)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-			//recycle the buffer
-			//ringnet.BufferPool.Put(thedata.buffer)
-			//delete(ringnet.userDataMap, thedata.id)
-
+			if fd := ringNet.acceptedFd(cqe); fd >= 0 {
+				c := newTCPConn(fd, ringNet)
+				ringNet.addConn(c)
+				ringNet.serve(c)
+			}
 		case uint32(prepareReader):
+			// a multishot receive is still armed while the completion says more are coming.
+			if c.reading = cqe.Flags()&uring.IORING_CQE_F_MORE != 0; !c.reading {
+				c.multishot = 0
+			}
+			if cqe.Result() == -int32(unix.ENOBUFS) && c.opened {
+				// every buffer is held for a while, they are given back as the completions holding them are handled.
+				ringNet.read(c)
+				continue
+			}
+			if cqe.Result() == -int32(unix.ECANCELED) && !timed && c.opened {
+				// the multishot receive is canceled by limitMultishot, the read goes on with a timeout.
+				ringNet.read(c)
+				continue
+			}
 			if cqe.Result() <= 0 {
+				if bid, ok := cqe.BufferID(); ok && ringNet.autoBuffer {
+					// the buffer picked by the read has to be given back even though nothing is in it.
+					ringNet.addBuffer(uint64(bid), ringNet.gid)
+				}
+				if cqe.Result() == 0 && c.tls != nil && !c.tls.krx && c.opened {
+					// the connection is closed by the TLS layer once the bytes received so far are decrypted.
+					c.tls.transport.closeRead()
+					continue
+				}
+				err := resultError("recv", cqe.Result(), timed)
+				if cqe.Result() == -int32(unix.EIO) && c.tls != nil && c.tls.krx {
+					// a record which isn't application data can't be received without its control message,
+					// it is the close_notify alert unless the peer misbehaves.
+					err = io.EOF
+				}
+				ringNet.close(c, err)
+				_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
 				continue
 			}
-			//fmt.Println(BytesToString(thedata.Buffer))
-			//log.Println("the buffer:", BytesToString(thedata.Buffer))
-			response(ringNet, thedata, ringing, 0)
-			continue
+			if !c.opened {
+				if bid, ok := cqe.BufferID(); ok && ringNet.autoBuffer {
+					ringNet.addBuffer(uint64(bid), ringNet.gid)
+				}
+				continue
+			}
+			c.received = true
+			if ringNet.autoBuffer {
+				bid, _ := cqe.BufferID()
+				ringNet.received(c, ringNet.buffer(bid)[:cqe.Result()])
+				//  recover kernel buffer; the buffer should be restored after using.
+				ringNet.addBuffer(uint64(bid), ringNet.gid)
+				// the callbacks may have set a deadline, which an action other than a read doesn't apply.
+				ringNet.limitMultishot(c)
+			} else {
+				ringNet.received(c, c.readBuffer[:cqe.Result()])
+			}
 		case uint32(PrepareWriter):
+			if cqe.Flags()&uring.IORING_CQE_F_NOTIF != 0 {
+				// the kernel doesn't reference the bytes of the zero-copy send any more, the record is released.
+				ringNet.notifs--
+				continue
+			}
+			if cqe.Flags()&uring.IORING_CQE_F_MORE != 0 {
+				ringNet.notifs++
+			}
+			c.outboundBuffer.inflight = false
+			c.releaseIovecs()
 			if cqe.Result() <= 0 {
+				ringNet.close(c, resultError("send", cqe.Result(), timed))
+				_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
 				continue
 			}
-			ringNet.Handler.OnWritten(*thedata)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
+			if !c.opened {
+				continue
+			}
+			c.outboundBuffer.Advance(int(cqe.Result()))
+			ringNet.written(c)
+		case uint32(spliceIn):
+			ringNet.splicedIn(c, cqe.Result())
+		case uint32(spliceOut):
+			ringNet.splicedOut(c, cqe.Result(), timed)
 		case uint32(closed):
-			ringNet.Handler.OnClose(*thedata)
-			//delete(ringnet.userDataMap, thedata.id)
-			ringNet.userDataList.Delete(thedata.id)
+			ringNet.closes--
+			action := ringNet.Handler.OnClose(c, closeErr)
+			// the connection may still be referenced by the user, its context is released anyway.
+			c.ctx = nil
+			if action == Shutdown {
+				ringNet.ringloop.stop()
+			}
+		}
+	}
+	ringNet.closeWakeup()
+	if ringNet.bufRing != nil {
+		_ = ringNet.bufRing.Close()
+	}
+	_ = ringNet.ring.Close()
+}
 
+// acceptedFd handles the completion of an accept and re-arms the accept if needed. It returns the accepted fd,
+// or -1 if there is no connection to open.
+func (ringNet *URingNet) acceptedFd(cqe uring.CQEntry) int {
+	// a multishot accept is still armed while the completion says more are coming.
+	more := cqe.Flags()&uring.IORING_CQE_F_MORE != 0
+	ringNet.accepting = more
+	if atomic.LoadInt32(&ringNet.inShutdown) == 1 {
+		// the accept is canceled, a connection accepted meanwhile is refused.
+		if cqe.Result() >= 0 {
+			_ = unix.Close(int(cqe.Result()))
+		}
+		return -1
+	}
+	if cqe.Result() < 0 {
+		// a negative result is an errno rather than an fd, there is no connection to open.
+		err := resultError("accept", cqe.Result(), false)
+		ringNet.logf("uringnet: accept error: %v", err)
+		if errno := unix.Errno(-cqe.Result()); !more && errno != unix.EINVAL && errno != unix.EBADF {
+			// the listener is still usable, e.g. the connection was aborted or fds run out for a while.
+			ringNet.EchoLoop()
 		}
+		return -1
+	}
+	if !more {
+		ringNet.EchoLoop()
 	}
+	return int(cqe.Result())
 }
 
-// Run is the core running cycle of io_uring, this function will use auto buffer.
-func (ringNet *URingNet) Run(ringing uint16) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	ringNet.Handler.OnBoot(ringNet)
-	//var connect_num uint32 = 0
-	for {
-		cqe, err := ringNet.ring.GetCQEntry(1)
+// resultError classifies the result of an operation which didn't transfer any byte into the reason why the
+// connection is closed: io.EOF when the peer has closed its side, errors.ErrTimeout when the operation is
+// canceled while timed reports that a timeout is linked to it, and otherwise an *os.SyscallError wrapping
+// the errno, such as ECONNRESET or EPIPE when the peer is gone, which is matched by errors.Is.
+// An operation without a timeout is only canceled by the engine itself, ECANCELED is reported as it is then.
+func resultError(op string, res int32, timed bool) error {
+	switch {
+	case res == 0 && op == "recv":
+		return io.EOF
+	case res == 0:
+		return io.ErrShortWrite
+	case res == -int32(unix.ECANCELED) && timed:
+		return errors.ErrTimeout
+	}
+	return os.NewSyscallError(op, unix.Errno(-res))
+}
 
-		//defer ringnet.ring.Close()
-		// have accepted
-		//theFd := ringnet.Fd.Load()
-		//if theFd != 0 {
-		//	sqe := ringnet.ring.GetSQEntry()
-		//	ringnet.read(int32(theFd), sqe, ringindex)
-		//	ringnet.Fd.Store(0)
-		//}
+// logf logs through ErrorLog, or the standard logger if it is nil.
+func (ringNet *URingNet) logf(format string, args ...interface{}) {
+	if ringNet.ErrorLog != nil {
+		ringNet.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
 
-		if err != nil {
-			if err == unix.EAGAIN {
-				//log.Println("Completion queue is empty!")
-				continue
-			}
-			//log.Println("uring has fatal error! ", err)
-			continue
-		}
+// ShutDown gracefully shuts down the loop which the ring belongs to, see Ringloop.Shutdown.
+//
+// Deprecated: use Ringloop.Shutdown, which is able to give up waiting.
+func (ringNet *URingNet) ShutDown() error {
+	return ringNet.ringloop.Shutdown(context.Background())
+}
 
-		data, suc := ringNet.userDataList.Load(cqe.UserData())
+// RegisterOnShutdown registers a function to call when the loop which the ring belongs to is shut down,
+// the function is called in its own goroutine as soon as the shutdown begins.
+func (ringNet *URingNet) RegisterOnShutdown(f func()) {
+	ringNet.mu.Lock()
+	ringNet.onShutdown = append(ringNet.onShutdown, f)
+	ringNet.mu.Unlock()
+}
 
-		//data, suc := ringnet.userDataMap[cqe.UserData()]
-		if !suc {
-			//log.Println("Cannot find matched userdata!")
-			//ringnet.ring.Flush()
-			continue
-		}
+// SetKeepAlivesEnabled controls whether the connections of the ring are kept alive between requests by the
+// protocols served on top of it, such as uringnet/http. They are enabled by default.
+func (ringNet *URingNet) SetKeepAlivesEnabled(v bool) {
+	if v {
+		atomic.StoreInt32(&ringNet.disableKeepAlives, 0)
+	} else {
+		atomic.StoreInt32(&ringNet.disableKeepAlives, 1)
+	}
+}
 
-		thedata := (data).(*UserData)
+// KeepAlivesEnabled reports whether the connections of the ring are kept alive between requests,
+// it is false once the loop is shutting down.
+func (ringNet *URingNet) KeepAlivesEnabled() bool {
+	return atomic.LoadInt32(&ringNet.disableKeepAlives) == 0 && atomic.LoadInt32(&ringNet.inShutdown) == 0
+}
 
-		//ioc := unix.Iovec{}
-		//ioc.SetLen(1)
-		switch thedata.state {
-		case uint32(provideBuffer):
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-		case uint32(accepted):
-			ringNet.Handler.OnOpen(thedata)
-			ringNet.EchoLoop()
-			Fd := cqe.Result()
-			//connect_num++
-			//log.Printf("URing Number: %d Client Conn %d: \n", ringindex, connect_num)
-			//log.Println("URing Number: ", ringindex, " Client Conn %d:", connect_num)
-
-			sqe := ringNet.ring.GetSQEntry()
-			//claim buffer for read
-			//buffer := make([]byte, 1024) //ringnet.BufferPool.Get().(*[]byte)
-			//temp := ringnet.BufferPool.Get()
-			//bb := temp.(*[]byte)
-			ringNet.read2(Fd, sqe)
-
-			//ringnet.read(Fd, sqe, ringindex)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-			//recycle the buffer
-			//ringnet.BufferPool.Put(thedata.buffer)
-			//delete(ringnet.userDataMap, thedata.id)
+// SetupNextProto prepares the ring for the application protocol proto, which is offered by ALPN if the ring
+// serves TLS. It is meant to be called by OnBoot, only the first call takes effect and its error is returned
+// by every call. A datagram ring can't serve a stream protocol.
+func (ringNet *URingNet) SetupNextProto(proto string) error {
+	ringNet.nextProtoOnce.Do(func() {
+		switch ringNet.Type {
+		case socket.Udp, socket.Udp4, socket.Udp6:
+			ringNet.nextProtoErr = errors.ErrUnsupportedProtocol
+			return
+		}
+		if ringNet.TLSConfig == nil {
+			return
+		}
+		for _, p := range ringNet.TLSConfig.NextProtos {
+			if p == proto {
+				return
+			}
+		}
+		// the config may be shared by other rings, it is left alone.
+		config := ringNet.TLSConfig.Clone()
+		config.NextProtos = append(config.NextProtos, proto)
+		ringNet.TLSConfig = config
+	})
+	return ringNet.nextProtoErr
+}
 
-		case uint32(prepareReader):
-			if cqe.Result() <= 0 {
-				continue
+// shutdown makes the ring stop accepting and close its connections, a connection which has bytes pending is
+// closed once they are sent. The ring leaves its running cycle when everything is closed.
+func (ringNet *URingNet) shutdown() {
+	atomic.StoreInt32(&ringNet.inShutdown, 1)
+	if ringNet.accepting {
+		sqe := ringNet.getSQE()
+		uring.AsyncCancel(sqe, ringNet.acceptID)
+	}
+	for _, id := range ringNet.dials {
+		uring.AsyncCancel(ringNet.getSQE(), id)
+	}
+	if ringNet.ringloop != nil {
+		ringNet.ringloop.connections.Range(func(_, value interface{}) bool {
+			c := value.(*conn)
+			if c.ringNet != ringNet {
+				return true
 			}
-			//log.Println("the buffer:", BytesToString(thedata.Buffer))
-			offset := uint64(cqe.Flags() >> uring.IORING_CQE_BUFFER_SHIFT)
-			thedata.Buffer = ringNet.Autobuffer[offset][:]
-			thedata.BufSize = cqe.Result()
-			//fmt.Println(BytesToString(thedata.Buffer))
-			//log.Println("the buffer:", BytesToString(thedata.Buffer))
-			responseWithBuffer(ringNet, thedata, ringing, offset)
-			continue
-		case uint32(PrepareWriter):
-			if cqe.Result() <= 0 {
-				continue
+			if c.outboundBuffer.Len() > 0 {
+				c.closing = true
+				ringNet.send(c)
+			} else {
+				ringNet.close(c, errors.ErrEngineShutdown)
+			}
+			return true
+		})
+		var sockets []*conn
+		ringNet.ringloop.mu.Lock()
+		for _, c := range ringNet.ringloop.udpSockets {
+			if c.ringNet == ringNet {
+				sockets = append(sockets, c)
 			}
-			ringNet.Handler.OnWritten(*thedata)
-			ringNet.userDataList.Delete(thedata.id)
-			continue
-		case uint32(closed):
-			ringNet.Handler.OnClose(*thedata)
-			//delete(ringnet.userDataMap, thedata.id)
-			ringNet.userDataList.Delete(thedata.id)
+		}
+		ringNet.ringloop.mu.Unlock()
+		for _, c := range sockets {
+			ringNet.close(c, errors.ErrEngineShutdown)
 		}
 	}
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
 }
 
-func (ringNet *URingNet) ShutDown() {
-	ringNet.ring.Flush()
-	ringNet.ring.Close()
-	ringNet.inShutdown = 1
-	ringNet.ReadBuffer = nil
-	ringNet.WriteBuffer = nil
-	ringNet.userDataMap = nil
-	ringNet.Handler.OnShutdown(ringNet)
+// drained reports whether the ring has nothing left to wait for during the shutdown.
+func (ringNet *URingNet) drained() bool {
+	return !ringNet.accepting && ringNet.closes == 0 && len(ringNet.dials) == 0 && ringNet.notifs == 0 &&
+		atomic.LoadUint32(&ringNet.Count) == 0
 }
 
-func response(ringnet *URingNet, data *UserData, gid uint16, offset uint64) {
+// received hands the bytes just read to OnTraffic, or to the TLS layer of the connection, which requests
+// the next read by itself.
+func (ringNet *URingNet) received(c *conn, b []byte) {
+	if c.tls == nil || c.tls.krx {
+		c.feed(b)
+		ringNet.response(c)
+		return
+	}
+	c.tls.transport.feed(b)
+}
 
-	action := ringnet.Handler.OnTraffic(data, ringnet)
+// response hands the bytes just read to OnTraffic and then carries out the returned action.
+func (ringNet *URingNet) response(c *conn) {
+	if c.codec != nil {
+		ringNet.decode(c)
+		return
+	}
+	action := ringNet.Handler.OnTraffic(c)
+	// the latest bytes belong to the read buffer which is going to be reused,
+	// whatever OnTraffic leaves is kept in the inbound buffer of the connection for the next read.
+	c.saveLeftover()
+	ringNet.act(c, action)
+}
 
+// act carries out the action returned by a callback of the connection.
+func (ringNet *URingNet) act(c *conn, action Action) {
 	switch action {
 	case Echo: // Echo: First write and then add another read event into SQEs.
-
-		//sqe2 := ringnet.ring.GetSQEntry()
-		sqe1 := ringnet.ring.GetSQEntry()
-
-		//ringnet.write(data, sqe2)
-		//ringnet.write(data, sqe1)
-		ringnet.send(data, sqe1, gid)
-
-		sqe := ringnet.ring.GetSQEntry()
-
-		ringnet.recv(data.Fd, sqe, gid)
-		//fmt.Println("read is set for uring ", gid)
-
+		ringNet.send(c)
+		ringNet.read(c)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
 	case Read:
-		sqe := ringnet.ring.GetSQEntry()
-		//ringnet.read2(data.Fd, sqe)
-		ringnet.recv(data.Fd, sqe, gid)
+		ringNet.read(c)
 	case Write:
-		sqe1 := ringnet.ring.GetSQEntry()
-		//ringnet.write(data, sqe1)
-		ringnet.send(data, sqe1, gid)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
+		ringNet.send(c)
+		_, err := ringNet.ring.Submit(0, &ringNet.enterFlags)
 		if err != nil {
-			fmt.Println("Error Message: ", err)
+			ringNet.logf("uringnet: submit: %v", err)
 		}
 		//EchoAndClose type just send a write event into SQEs and then close the socket connection. the write and close event should be linked together.
 	case EchoAndClose:
-		sqe2 := ringnet.ring.GetSQEntry()
-		// claim buffer for I/O write
-		//bw := ringnet.BufferPool.Get().(*[]byte)
-		//bw := make([]byte, 1024)
-		//sqe2.SetFlags(uring.IOSQE_IO_LINK)
-		//ringnet.write(data, sqe2)
-		ringnet.send(data, sqe2, gid)
-		sqe := ringnet.ring.GetSQEntry()
-		sqe.SetFlags(uring.IOSQE_IO_DRAIN)
-		ringnet.close(data, sqe)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
+		ringNet.send(c)
+		if c.outboundBuffer.Len() > 0 {
+			// the connection is closed after the last byte is sent.
+			c.closing = true
+		} else {
+			ringNet.close(c, nil)
+		}
+		_, err := ringNet.ring.Submit(0, &ringNet.enterFlags)
 		if err != nil {
-			fmt.Println("Error Message: ", err)
+			ringNet.logf("uringnet: submit: %v", err)
 		}
 	case Close:
-		sqe := ringnet.ring.GetSQEntry()
-
-		ringnet.close(data, sqe)
-
+		ringNet.close(c, nil)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+	case Shutdown:
+		ringNet.ringloop.stop()
 	}
-	//  recover kernel buffer; the buffer should be restored after using.
-
-	//  remove the userdata in this loop
-	//data.Buffer = nil
-	//data.WriteBuf = nil
-	ringnet.userDataList.Delete(data.id)
-	//delete(ringnet.userDataMap, data.id)
 }
 
-// Run is the core running cycle of io_uring, this function will use auto buffer.
-func responseWithBuffer(ringnet *URingNet, data *UserData, gid uint16, offset uint64) {
-
-	action := ringnet.Handler.OnTraffic(data, ringnet)
-
-	switch action {
-	case Echo: // Echo: First write and then add another read event into SQEs.
-
-		sqe1 := ringnet.ring.GetSQEntry()
-		ringnet.write(data, sqe1)
+// open fires OnOpen of a newly accepted connection, the greeting it returns is sent right away. The connection
+// starts reading unless the action says otherwise, Close rejects it.
+func (ringNet *URingNet) open(c *conn) {
+	out, action := ringNet.Handler.OnOpen(c)
+	_, _ = c.write(out)
+	if action == None {
+		action = Echo
+	}
+	ringNet.act(c, action)
+}
 
-		sqe := ringnet.ring.GetSQEntry()
-		ringnet.read(data.Fd, sqe, gid)
-		//fmt.Println("read is set for uring ", gid)
+// addConn registers a newly opened connection in the loop.
+func (ringNet *URingNet) addConn(c *conn) {
+	c.opened = true
+	if c.isDatagram {
+		ringNet.ringloop.mu.Lock()
+		ringNet.ringloop.udpSockets[c.fd] = c
+		ringNet.ringloop.mu.Unlock()
+	} else {
+		ringNet.ringloop.connections.Store(c.fd, c)
+	}
+	atomic.AddUint32(&ringNet.Count, 1)
+}
 
-	case Read:
-		sqe := ringnet.ring.GetSQEntry()
-		ringnet.read(data.Fd, sqe, gid)
-	case Write:
-		sqe1 := ringnet.ring.GetSQEntry()
-		ringnet.write(data, sqe1)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
-		if err != nil {
-			fmt.Println("Error Message: ", err)
+// maxLinkedSQEs is the length of the longest chain of SQEs the ring links, i.e. the splice of a file to a
+// socket with a timeout.
+const maxLinkedSQEs = 3
+
+// reserve makes room for n SQEs, the SQ is submitted while it is too full. The SQ of a polled ring is
+// consumed by the kernel thread, which is waited for then.
+func (ringNet *URingNet) reserve(n uint32) {
+	for ringNet.ring.SQSpaceLeft() < n {
+		if _, err := ringNet.ring.Submit(0, &ringNet.enterFlags); err != nil {
+			ringNet.logf("uringnet: submit: %v", err)
+			return
 		}
-		//EchoAndClose type just send a write event into SQEs and then close the socket connection. the write and close event should be linked together.
-	case EchoAndClose:
-		sqe2 := ringnet.ring.GetSQEntry()
-		ringnet.write(data, sqe2)
-		sqe := ringnet.ring.GetSQEntry()
-		sqe.SetFlags(uring.IOSQE_IO_DRAIN)
-		ringnet.close(data, sqe)
-		_, err := ringnet.ring.Submit(0, &paraFlags)
-		if err != nil {
-			fmt.Println("Error Message: ", err)
+		if ringNet.ring.SQSpaceLeft() < n {
+			runtime.Gosched()
 		}
-	case Close:
-		sqe := ringnet.ring.GetSQEntry()
-		ringnet.addBuffer(offset, gid)
-		ringnet.close(data, sqe)
-
 	}
-	//  recover kernel buffer; the buffer should be restored after using.
-	ringnet.addBuffer(offset, gid)
-	//  remove the userdata in this loop
-	ringnet.userDataList.Delete(data.id)
-	//delete(ringnet.userDataMap, data.id)
 }
 
-func (ringNet *URingNet) close(thedata *UserData, sqe *uring.SQEntry) {
-	data := makeUserData(closed)
-	data.Fd = thedata.Fd
-	ringNet.userDataList.Store(data.id, data)
-	//ringnet.userDataMap[data.id] = data
+// getSQE returns an SQE which may start a chain, room is made for the longest chain first so that the
+// chain is never split by a submit. The SQEs after the first one of a chain are taken by ring.GetSQEntry.
+func (ringNet *URingNet) getSQE() *uring.SQEntry {
+	ringNet.reserve(maxLinkedSQEs)
+	return ringNet.ring.GetSQEntry()
+}
 
+// close submits the close of the connection, the connection is unregistered before its fd is released
+// so that the fd number can't be reused by another ring while it is still in the connection map.
+// The socket is shut down first, which completes a read in flight, because the read holds a reference
+// to the socket and the close alone would not release it. err is reported to OnClose.
+// A connection which is closed already is left alone.
+func (ringNet *URingNet) close(c *conn, err error) {
+	if !c.opened {
+		return
+	}
+	c.opened = false
+	if c.ephemeral {
+		// the socket belongs to the engine, the connection is just dropped.
+		return
+	}
+	c.closePipe()
+	if c.tls != nil {
+		_ = c.tls.transport.Close()
+		if !c.tls.established {
+			c.tls.offloaded <- true
+		}
+	}
+	if c.isDatagram {
+		ringNet.ringloop.mu.Lock()
+		delete(ringNet.ringloop.udpSockets, c.fd)
+		ringNet.ringloop.mu.Unlock()
+	} else {
+		ringNet.ringloop.connections.Delete(c.fd)
+	}
+	atomic.AddUint32(&ringNet.Count, ^uint32(0))
+	ringNet.closes++
+	sqe := ringNet.getSQE()
+	uring.Shutdown(sqe, uintptr(c.fd), unix.SHUT_RDWR)
+	// the close must be carried out even though the shutdown fails.
+	sqe.SetFlags(uring.IOSQE_IO_HARDLINK)
+
+	data := ringNet.ops.get(closed)
+	data.Fd = int32(c.fd)
+	data.conn = c
+	data.err = err
+
+	sqe = ringNet.ring.GetSQEntry()
 	sqe.SetUserData(data.id)
-	sqe.SetLen(1)
-	uring.Close(sqe, uintptr(thedata.Fd))
-	//return data
-}
-
-func (ringNet *URingNet) write(thedata *UserData, sqe2 *uring.SQEntry) {
-	data1 := makeUserData(PrepareWriter)
-	data1.Fd = thedata.Fd
-	//thebuffer := make([]byte, 1024)
-	//thedata.buffer = thebuffer
-	//copy(thebuffer, thedata.buffer)
-	ringNet.userDataList.Store(data1.id, data1)
-	//ringnet.userDataMap[data1.id] = data1
-	//ringnet.mu.Unlock()
-	sqe2.SetUserData(data1.id)
-	//sqe2.SetFlags(uring.IOSQE_IO_LINK)
-	uring.Write(sqe2, uintptr(data1.Fd), thedata.WriteBuf)
-
-	//uring.write(sqe2, uintptr(data1.Fd), thedata.Buffer) //data.WriteBuf)
-	//ringnet.ring.Submit(0, &paraFlags)
-}
-func (ringNet *URingNet) write2(Fd int32, buffer []byte) {
-	sqe2 := ringNet.ring.GetSQEntry()
-	data1 := makeUserData(PrepareWriter)
-	data1.Fd = Fd
-
-	//ringnet.userDataMap[data1.id] = data1
-	ringNet.userDataList.Store(data1.id, data1)
-	//ringnet.mu.Unlock()
-	sqe2.SetUserData(data1.id)
-
-	uring.Write(sqe2, uintptr(data1.Fd), buffer)
-	ringNet.ring.Submit(0, &paraFlags)
-
-}
-
-// read method when using auto buffer
-func (ringNet *URingNet) read(Fd int32, sqe *uring.SQEntry, ringIndex uint16) {
-	data2 := makeUserData(prepareReader)
-	data2.Fd = Fd
-	//data2.buffer = make([]byte, 1024)
-	//data2.bytebuffer = buffer
-	//data2.client = thedata.client
-	sqe.SetUserData(data2.id)
+	uring.Close(sqe, uintptr(c.fd))
+}
+
+// read adds a read event of the connection into SQEs and submits it, unless a read is already in flight.
+func (ringNet *URingNet) read(c *conn) {
+	if !c.opened {
+		return
+	}
+	if c.reading {
+		ringNet.limitMultishot(c)
+		return
+	}
+	if c.isDatagram {
+		// the datagrams of the socket the engine listens on are received regardless of the connections.
+		if !c.ephemeral {
+			ringNet.recvMsg(c)
+		}
+		return
+	}
+	timeout := ringNet.readTimeout(c)
+	if timeout < 0 {
+		ringNet.close(c, errors.ErrTimeout)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		return
+	}
+	c.reading = true
+	sqe := ringNet.getSQE()
+	if ringNet.autoBuffer && timeout == 0 && ringNet.bufRing != nil && ringNet.features.multishotRecv &&
+		(c.tls == nil || c.tls.krx) {
+		ringNet.recvMultishot(c, sqe, ringNet.gid)
+	} else if ringNet.autoBuffer {
+		ringNet.readAuto(c, sqe, ringNet.gid, timeout)
+	} else {
+		ringNet.recv(c, sqe, ringNet.gid, timeout)
+	}
+}
 
-	//ioc := unix.Iovec{}
-	//ioc.SetLen(1)
+// readAuto method when using auto buffer
+func (ringNet *URingNet) readAuto(c *conn, sqe *uring.SQEntry, ringIndex uint16, timeout time.Duration) {
+	data2 := ringNet.ops.get(prepareReader)
+	data2.Fd = int32(c.fd)
+	data2.conn = c
+	sqe.SetUserData(data2.id)
 
 	//Add read event
 	sqe.SetFlags(uring.IOSQE_BUFFER_SELECT)
 	sqe.SetBufGroup(ringIndex)
-	//uring.Read(sqe, uintptr(data2.Fd), ringnet.ReadBuffer)
-	uring.ReadNoBuf(sqe, uintptr(Fd), uint32(bufLength))
+	uring.ReadNoBuf(sqe, uintptr(c.fd), uint32(bufLength))
+	if timeout > 0 {
+		ringNet.linkTimeout(sqe, data2, timeout)
+	}
 
-	//ringnet.userDataList.Store(data2.id, data2)
-	//co := conn{}
-	//co.fd = data2.Fd
-	//co.rawSockAddr = sqe.
-	//ringnet.ringloop.connections.Store(data2.Fd)
-	//ringnet.userDataMap[data2.id] = data2
-	ringNet.userDataList.Store(data2.id, data2)
+	//enterFlags = uring.IORING_SETUP_SQPOLL
+	ringNet.ring.Submit(0, &ringNet.enterFlags)
+}
 
-	//paraFlags = uring.IORING_SETUP_SQPOLL
-	ringNet.ring.Submit(0, &paraFlags)
+// recvMultishot adds a multishot receive of the connection into SQEs and submits it. It stays armed until the
+// connection is closed, every completion carries a buffer of the buffer ring. A read timeout can't be linked to
+// it, so it isn't used while one applies, see limitMultishot.
+func (ringNet *URingNet) recvMultishot(c *conn, sqe *uring.SQEntry, ringIndex uint16) {
+	data := ringNet.ops.get(prepareReader)
+	data.Fd = int32(c.fd)
+	data.conn = c
+	sqe.SetUserData(data.id)
+	sqe.SetFlags(uring.IOSQE_BUFFER_SELECT)
+	sqe.SetBufGroup(ringIndex)
+	uring.RecvMultishot(sqe, uintptr(c.fd), 0)
+	c.multishot = data.id
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
 }
 
-func (ringNet *URingNet) recv(Fd int32, sqe *uring.SQEntry, ringIndex uint16) {
-	data2 := makeUserData(prepareReader)
-	data2.Fd = Fd
-	sqe.SetUserData(data2.id)
-	uring.Recv(sqe, uintptr(Fd), ringNet.ReadBuffer, 0)
-	ringNet.userDataList.Store(data2.id, data2)
-	//paraFlags = uring.IORING_ENTER_SQ_WAKEUP
-	//}
-	ringNet.ring.Submit(0, &paraFlags)
+// limitMultishot cancels the multishot receive of the connection once a read timeout applies to it, e.g. a
+// deadline has been set since it was armed. Its last completion carries -ECANCELED, which arms a single-shot
+// receive with the timeout linked.
+func (ringNet *URingNet) limitMultishot(c *conn) {
+	if c.multishot == 0 || !c.opened || ringNet.readTimeout(c) == 0 {
+		return
+	}
+	uring.AsyncCancel(ringNet.getSQE(), c.multishot)
+	c.multishot = 0
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
 }
 
-func (ringNet *URingNet) send(thedata *UserData, sqe *uring.SQEntry, ringIndex uint16) {
-	data2 := makeUserData(PrepareWriter)
-	data2.Fd = thedata.Fd
+func (ringNet *URingNet) recv(c *conn, sqe *uring.SQEntry, ringIndex uint16, timeout time.Duration) {
+	data2 := ringNet.ops.get(prepareReader)
+	data2.Fd = int32(c.fd)
+	data2.conn = c
 	sqe.SetUserData(data2.id)
-	uring.Send(sqe, uintptr(data2.Fd), thedata.WriteBuf, unix.MSG_DONTWAIT|unix.MSG_ZEROCOPY)
-	ringNet.userDataList.Store(data2.id, data2)
+	uring.Recv(sqe, uintptr(c.fd), c.readBuffer, 0)
+	if timeout > 0 {
+		ringNet.linkTimeout(sqe, data2, timeout)
+	}
+	ringNet.ring.Submit(0, &ringNet.enterFlags)
 }
 
-func (ringNet *URingNet) read_multi(Fd int32, sqes []*uring.SQEntry, ringIndex uint16) {
-	data2 := makeUserData(prepareReader)
-	data2.Fd = Fd
-	for _, sqe := range sqes {
-		sqe.SetUserData(data2.id)
-
-		//Add read event
-		sqe.SetFlags(uring.IOSQE_BUFFER_SELECT)
-		sqe.SetBufGroup(ringIndex)
-		uring.ReadNoBuf(sqe, uintptr(Fd), uint32(bufLength))
-		ringNet.userDataList.Store(data2.id, data2)
+// send adds a send event of the head of the outbound buffer into SQEs. Only one send of a connection is
+// in flight at a time, the completion submits what is left, which keeps the bytes in order.
+func (ringNet *URingNet) send(c *conn) {
+	if c.isDatagram {
+		ringNet.sendMsgs(c)
+		return
 	}
-	//sqes的长度如何获取:
-	ringNet.ring.Submit(uint32(len(sqes)), &paraFlags)
-}
+	if c.outboundBuffer.inflight || c.outboundBuffer.Len() == 0 {
+		return
+	}
+	timeout := ringNet.writeTimeout(c)
+	if timeout < 0 {
+		ringNet.close(c, errors.ErrTimeout)
+		return
+	}
+	if f := c.outboundBuffer.HeadFile(); f != nil {
+		ringNet.spliceFile(c, f, timeout)
+		return
+	}
+	bufs := c.outboundBuffer.Heads(c.sendBufs[:0], maxSendIovecs)
+	data2 := ringNet.ops.get(PrepareWriter)
+	data2.Fd = int32(c.fd)
+	data2.conn = c
+	c.outboundBuffer.inflight = true
 
-// this function is used to read data from the network socket without auto buffer.
-func (ringNet *URingNet) read2(Fd int32, sqe *uring.SQEntry) {
-	data2 := makeUserData(prepareReader)
-	data2.Fd = Fd
+	sqe := ringNet.getSQE()
 	sqe.SetUserData(data2.id)
+	if len(bufs) > 1 {
+		// the byte slices are gathered by one send, the iovecs of the connection keep them alive until it
+		// completes. A short send stops anywhere in them, Advance carries on from there.
+		c.iovecs = bytes2iovec(c.iovecs[:0], bufs)
+		c.iovHdr.Iov = &c.iovecs[0]
+		c.iovHdr.SetIovlen(len(c.iovecs))
+		uring.SendMsg(sqe, uintptr(c.fd), &c.iovHdr, 0)
+	} else {
+		// the userdata keeps the bytes alive until the kernel has completed the send.
+		data2.WriteBuf = bufs[0]
+		// kTLS refuses zero-copy sends, the kernel encrypts into buffers of its own anyway.
+		if ringNet.features.sendZC && len(data2.WriteBuf) >= zeroCopyThreshold && (c.tls == nil || !c.tls.ktx) {
+			// the record keeps the bytes alive until the notification of the kernel.
+			uring.SendZC(sqe, uintptr(c.fd), data2.WriteBuf, 0, 0)
+		} else {
+			uring.Send(sqe, uintptr(c.fd), data2.WriteBuf, 0)
+		}
+	}
+	// the scratch slice doesn't hold on to the bytes.
+	for i := range bufs {
+		bufs[i] = nil
+	}
+	c.sendBufs = bufs[:0]
+	if timeout > 0 {
+		ringNet.linkTimeout(sqe, data2, timeout)
+	}
+}
 
-	//data2.Buffer = make([]byte, 1024)
-	//ringnet.userDataMap[data2.id] = data2
-	ringNet.userDataList.Store(data2.id, data2)
-
-	uring.Read(sqe, uintptr(Fd), ringNet.ReadBuffer)
+// written carries on after a send of the connection completed, what is left is sent and OnWritten fires
+// once everything is sent.
+func (ringNet *URingNet) written(c *conn) {
+	if c.outboundBuffer.Len() > 0 {
+		// the send is short or more bytes have been written meanwhile, carry on with the rest.
+		ringNet.send(c)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+		return
+	}
+	if c.tls != nil && !c.tls.established {
+		// the bytes of the handshake are sent, OnWritten fires for the bytes written once the connection is open.
+		if c.tls.draining {
+			ringNet.establish(c)
+		}
+		return
+	}
+	ringNet.act(c, ringNet.Handler.OnWritten(c))
+	if c.closing {
+		ringNet.close(c, nil)
+		_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
+	}
+}
 
-	ringNet.ring.Submit(0, &paraFlags)
+// tick fires OnTick and adds a timeout event into SQEs which completes after the returned delay,
+// the ticking stops when the delay is not positive.
+func (ringNet *URingNet) tick() {
+	delay, action := ringNet.Handler.OnTick()
+	if action == Shutdown {
+		if ringNet.ringloop != nil {
+			ringNet.ringloop.stop()
+		} else {
+			ringNet.shutdown()
+		}
+		return
+	}
+	if delay <= 0 {
+		return
+	}
+	ringNet.tickTs = unix.NsecToTimespec(int64(delay))
+	data := ringNet.ops.get(ticking)
+	sqe := ringNet.getSQE()
+	sqe.SetUserData(data.id)
+	uring.Timeout(sqe, &ringNet.tickTs, false, 0)
+	_, _ = ringNet.ring.Submit(0, &ringNet.enterFlags)
 }
 
 // New Creates a new uRingnNet which is used to
@@ -584,21 +861,22 @@ func New(addr NetAddress, size uint, sqpoll bool, options socket.SocketOptions)
 	//1. set the socket
 	//var ringNet *URingNet
 	ringNet := &URingNet{}
-	ringNet.userDataMap = make(map[uint64]*UserData)
 	ops := socket.SetOptions(string(addr.AddrType), options)
 	switch addr.AddrType {
 	case socket.Tcp, socket.Tcp4, socket.Tcp6:
 		ringNet.SocketFd, _, _ = socket.TCPSocket(string(addr.AddrType), addr.Address, true, ops...) //ListenTCPSocket(addr)
 	case socket.Udp, socket.Udp4, socket.Udp6:
-		ringNet.SocketFd, _, _ = socket.UDPSocket(string(addr.AddrType), addr.Address, true, ops...)
+		ringNet.SocketFd, _, _ = socket.UDPSocket(string(addr.AddrType), addr.Address, false, ops...)
 	case socket.Unix:
-		ringNet.SocketFd, _, _ = socket.UnixSocket(string(addr.AddrType), addr.Address, true, ops...)
+		ringNet.SocketFd, _, _ = socket.UnixListenerSocket(string(addr.AddrType), addr.Address, options.UnixSocketMode, ops...)
 
 	default:
 		ringNet.SocketFd = -1
 	}
 	ringNet.Addr = addr.Address
 	ringNet.Type = addr.AddrType
+	ringNet.ticker = options.Ticker
+	ringNet.done = make(chan struct{})
 
 	//ringNet.userDataList = make(sync.Map, 1024)
 	//Create the io_uring instance
@@ -627,9 +905,9 @@ func NewMany(addr NetAddress, size uint, sqpoll bool, num int, options socket.So
 	case socket.Tcp, socket.Tcp4, socket.Tcp6:
 		sockfd, _, _ = socket.TCPSocket(string(addr.AddrType), addr.Address, true, ops...) //ListenTCPSocket(addr)
 	case socket.Udp, socket.Udp4, socket.Udp6:
-		sockfd, _, _ = socket.UDPSocket(string(addr.AddrType), addr.Address, true, ops...)
+		sockfd, _, _ = socket.UDPSocket(string(addr.AddrType), addr.Address, false, ops...)
 	case socket.Unix:
-		sockfd, _, _ = socket.UnixSocket(string(addr.AddrType), addr.Address, true, ops...)
+		sockfd, _, _ = socket.UnixListenerSocket(string(addr.AddrType), addr.Address, options.UnixSocketMode, ops...)
 	default:
 		sockfd = -1
 	}
@@ -639,19 +917,20 @@ func NewMany(addr NetAddress, size uint, sqpoll bool, num int, options socket.So
 	for i := 0; i < num; i++ {
 		uringArray[i] = &URingNet{}
 		//uringArray[i].userDataMap = make(map[uint64]*UserData)
-		uringArray[i].ReadBuffer = make([]byte, 1024)
 		uringArray[i].WriteBuffer = make([]byte, 1024)
 		uringArray[i].SocketFd = sockfd
 		uringArray[i].Addr = addr.Address
 		uringArray[i].Type = addr.AddrType
 		uringArray[i].Handler = handler
+		uringArray[i].ticker = options.Ticker
+		uringArray[i].lb = options.LB
+		uringArray[i].done = make(chan struct{})
 
 		if sqpoll {
 			uringArray[i].SetUring(size, &uring.IOUringParams{Flags: uring.IORING_SETUP_SQPOLL, Features: uring.IORING_FEAT_NODROP | uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_SQPOLL_NONFIXED}) //Features: uring.IORING_FEAT_FAST_POLL|uring.IORING_FEAT_NODROP})
 		} else {
 			uringArray[i].SetUring(size, &uring.IOUringParams{Features: uring.IORING_FEAT_FAST_POLL | uring.IORING_FEAT_NODROP})
 		}
-		fmt.Println("Uring instance initiated!")
 	}
 	return uringArray, nil
 }
@@ -661,14 +940,24 @@ type NetAddress struct {
 	Address  string
 }
 
+// buffer returns the provided buffer bid which a completion picked.
+func (ringNet *URingNet) buffer(bid uint16) []byte {
+	if ringNet.bufRing != nil {
+		return ringNet.bufRing.Buffer(bid)
+	}
+	return ringNet.Autobuffer[bid][:]
+}
+
 // addBuffer  kernel buffer should be restored after using
 
 func (ringNet *URingNet) addBuffer(offset uint64, gid uint16) {
-	sqe := ringNet.ring.GetSQEntry()
+	if ringNet.bufRing != nil {
+		ringNet.bufRing.Recycle(uint16(offset))
+		return
+	}
+	sqe := ringNet.getSQE()
 	uring.ProvideSingleBuf(sqe, &ringNet.Autobuffer[offset], 1, uint32(bufLength), gid, offset)
-	data := makeUserData(provideBuffer)
+	data := ringNet.ops.get(provideBuffer)
 	sqe.SetUserData(data.id)
-	ringNet.userDataList.Store(data.id, data)
-	//ringNet.ringloop.ringNet.userDataMap[data.id] = data
 	//_, _ = ringNet.ring.Submit(0, nil)
 }
@@ -0,0 +1,1761 @@
+//go:build linux
+// +build linux
+
+package uringnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	uerrors "github.com/y001j/uringnet/errors"
+	socket "github.com/y001j/uringnet/sockets"
+	"golang.org/x/sys/unix"
+)
+
+type echoServer struct {
+	BuiltinEventEngine
+
+	opened chan Conn
+}
+
+func (es *echoServer) OnOpen(c Conn) ([]byte, Action) {
+	if es.opened != nil {
+		es.opened <- c
+	}
+	return nil, None
+}
+
+func (es *echoServer) OnTraffic(c Conn) Action {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return Echo
+}
+
+// startEngine creates rings listening on addr, runs them and returns the address which is listened on.
+func startEngine(t *testing.T, network socket.NetAddressType, addr string, rings int, handler EventHandler) (*Ringloop, string) {
+	t.Helper()
+	return startEngineWithOptions(t, network, addr, rings, socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}, handler)
+}
+
+// startEngineWithOptions is like startEngine, every ring is passed to setup before it runs.
+func startEngineWithOptions(t *testing.T, network socket.NetAddressType, addr string, rings int, options socket.SocketOptions, handler EventHandler, setup ...func(*URingNet)) (*Ringloop, string) {
+	t.Helper()
+	ringNets, err := NewMany(NetAddress{AddrType: network, Address: addr}, 64, false, rings, options, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ringNet := range ringNets {
+		for _, f := range setup {
+			f(ringNet)
+		}
+	}
+	loop := SetLoops(ringNets, 64)
+	if loop == nil {
+		t.Fatal("failed to set the ring loops")
+	}
+	sa, err := unix.Getsockname(ringNets[0].SocketFd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop.RunMany()
+	return loop, socket.SockaddrToTCPOrUnixAddr(sa).String()
+}
+
+func dial(t *testing.T, network, addr string) net.Conn {
+	t.Helper()
+	c, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func expectEcho(t *testing.T, c net.Conn, msg []byte) {
+	t.Helper()
+	if _, err := c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("expect echo %q, but got %q", msg, got)
+	}
+}
+
+func TestEchoConn(t *testing.T) {
+	handler := &echoServer{opened: make(chan Conn, 1)}
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+
+	var c Conn
+	select {
+	case c = <-handler.opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnOpen was not fired")
+	}
+	if c.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Fatalf("expect remote address %s, but got %s", client.LocalAddr(), c.RemoteAddr())
+	}
+	if c.LocalAddr().String() != client.RemoteAddr().String() {
+		t.Fatalf("expect local address %s, but got %s", client.RemoteAddr(), c.LocalAddr())
+	}
+
+	for i := 0; i < 10; i++ {
+		expectEcho(t, client, []byte("hello uringnet"))
+	}
+}
+
+func TestAccept(t *testing.T) {
+	for _, multishot := range []bool{true, false} {
+		multishot := multishot
+		name := "single-shot"
+		if multishot {
+			name = "multishot"
+		}
+		t.Run(name, func(t *testing.T) {
+			supported := true
+			_, addr := startEngineWithOptions(t, socket.Tcp4, "127.0.0.1:0", 1, socket.SocketOptions{}, &echoServer{},
+				func(ringNet *URingNet) {
+					supported = ringNet.features.multishotAccept
+					ringNet.features.multishotAccept = multishot && supported
+				})
+			if multishot && !supported {
+				t.Skip("multishot accept is not supported by the kernel")
+			}
+
+			// every connection is accepted, whether the accept stays armed or is submitted again.
+			clients := make([]net.Conn, 20)
+			for i := range clients {
+				clients[i] = dial(t, "tcp", addr)
+				defer clients[i].Close()
+			}
+			for _, client := range clients {
+				expectEcho(t, client, []byte("hello accept"))
+			}
+		})
+	}
+}
+
+// ringServer echoes and reports the ring which every connection is opened on.
+type ringServer struct {
+	echoServer
+
+	rings chan *URingNet
+}
+
+func (rs *ringServer) OnOpen(c Conn) ([]byte, Action) {
+	rs.rings <- c.(*conn).ringNet
+	return nil, None
+}
+
+func TestAcceptorLoadBalancing(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		lb   socket.LoadBalancing
+	}{
+		{"round-robin", socket.RoundRobin},
+		{"least-connections", socket.LeastConnections},
+		{"source-addr-hash", socket.SourceAddrHash},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &ringServer{rings: make(chan *URingNet, 6)}
+			ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 64, false, 3,
+				socket.SocketOptions{LB: tc.lb}, handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loop := SetLoops(ringNets, 64)
+			if loop == nil {
+				t.Fatal("failed to set the ring loops")
+			}
+			sa, err := unix.Getsockname(ringNets[0].SocketFd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := socket.SockaddrToTCPOrUnixAddr(sa).String()
+			if err = loop.RunManyAcceptor(); err != nil {
+				t.Fatal(err)
+			}
+
+			// the connections are kept open, so every ring serves as many connections as the algorithm gave it.
+			counts := make(map[*URingNet]int)
+			for i := 0; i < 6; i++ {
+				client := dial(t, "tcp", addr)
+				defer client.Close()
+				expectEcho(t, client, []byte("hello acceptor"))
+				counts[<-handler.rings]++
+			}
+			if tc.lb == socket.SourceAddrHash {
+				if len(counts) != 1 {
+					t.Fatalf("expect the connections from one address to go to one ring, but got %d rings", len(counts))
+				}
+			} else {
+				for _, ringNet := range ringNets {
+					if counts[ringNet] != 2 {
+						t.Fatalf("expect 2 connections on every ring, but got %v", counts)
+					}
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err = loop.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+				c.Close()
+				t.Fatal("the listener is still open after the shutdown")
+			}
+		})
+	}
+}
+
+// bufferServer echoes and reports whether the ring of every connection reads into the provided buffers.
+type bufferServer struct {
+	echoServer
+
+	autoBuffer chan bool
+}
+
+func (bs *bufferServer) OnOpen(c Conn) ([]byte, Action) {
+	bs.autoBuffer <- c.(*conn).ringNet.autoBuffer
+	return nil, None
+}
+
+func TestRunManyVariants(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		run        func(*Ringloop) error
+		autoBuffer bool
+	}{
+		{"RunMany", func(loop *Ringloop) error { loop.RunMany(); return nil }, false},
+		{"RunMany2", func(loop *Ringloop) error { loop.RunMany2(); return nil }, true},
+		{"RunManyAcceptor", (*Ringloop).RunManyAcceptor, false},
+		{"RunManyAcceptor2", (*Ringloop).RunManyAcceptor2, true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &bufferServer{autoBuffer: make(chan bool, 4)}
+			ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 64, false, 2,
+				socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}, handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loop := SetLoops(ringNets, 64)
+			if loop == nil {
+				t.Fatal("failed to set the ring loops")
+			}
+			sa, err := unix.Getsockname(ringNets[0].SocketFd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := socket.SockaddrToTCPOrUnixAddr(sa).String()
+			if err = tc.run(loop); err != nil {
+				t.Fatal(err)
+			}
+
+			// the payload spans many buffers of every kind.
+			msg := bytes.Repeat([]byte("0123456789abcdef"), 4<<10)
+			for i := 0; i < 4; i++ {
+				client := dial(t, "tcp", addr)
+				defer client.Close()
+				if autoBuffer := <-handler.autoBuffer; autoBuffer != tc.autoBuffer {
+					t.Fatalf("expect the ring to read into the provided buffers %v, but got %v", tc.autoBuffer, autoBuffer)
+				}
+				go func() {
+					_, _ = client.Write(msg)
+				}()
+				got := make([]byte, len(msg))
+				if _, err = io.ReadFull(client, got); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, msg) {
+					t.Fatal("the payload is corrupted")
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err = loop.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// tlsServer echoes and reports the state of the TLS connection when it is opened.
+type tlsServer struct {
+	echoServer
+
+	states chan tls.ConnectionState
+}
+
+func (ts *tlsServer) OnOpen(c Conn) ([]byte, Action) {
+	state, ok := c.ConnectionState()
+	if !ok {
+		return nil, Close
+	}
+	ts.states <- state
+	return []byte("hello tls"), None
+}
+
+// selfSignedCert creates a certificate for 127.0.0.1 which is valid for both servers and clients.
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "uringnet"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func TestTLS(t *testing.T) {
+	cert := selfSignedCert(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		version := version
+		t.Run(tls.VersionName(version), func(t *testing.T) {
+			handler := &tlsServer{states: make(chan tls.ConnectionState, 1)}
+			_, addr := startEngineWithOptions(t, socket.Tcp4, "127.0.0.1:0", 1, socket.SocketOptions{}, handler,
+				func(ringNet *URingNet) {
+					ringNet.TLSConfig = &tls.Config{
+						Certificates: []tls.Certificate{cert},
+						NextProtos:   []string{"echo/1"},
+						ClientAuth:   tls.RequireAndVerifyClientCert,
+						ClientCAs:    pool,
+					}
+				})
+
+			raw := dial(t, "tcp", addr)
+			client := tls.Client(raw, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      pool,
+				ServerName:   "127.0.0.1",
+				NextProtos:   []string{"echo/1"},
+				MinVersion:   version,
+				MaxVersion:   version,
+			})
+			defer client.Close()
+
+			// the greeting of OnOpen is encrypted like everything else.
+			greeting := make([]byte, len("hello tls"))
+			if _, err := io.ReadFull(client, greeting); err != nil {
+				t.Fatal(err)
+			}
+			if string(greeting) != "hello tls" {
+				t.Fatalf("expect the greeting %q, but got %q", "hello tls", greeting)
+			}
+			state := <-handler.states
+			if state.NegotiatedProtocol != "echo/1" {
+				t.Fatalf("expect the ALPN protocol %q, but got %q", "echo/1", state.NegotiatedProtocol)
+			}
+			if state.Version != version {
+				t.Fatalf("expect %s, but got %s", tls.VersionName(version), tls.VersionName(state.Version))
+			}
+			if len(state.PeerCertificates) != 1 || !state.PeerCertificates[0].Equal(cert.Leaf) {
+				t.Fatalf("expect the certificate of the client, but got %d certificates", len(state.PeerCertificates))
+			}
+
+			// the payload spans several records on its way in and out.
+			expectEcho(t, client, []byte("hello"))
+			expectEcho(t, client, bytes.Repeat([]byte("0123456789"), 10000))
+		})
+	}
+}
+
+func TestTLSOffloadConfig(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	tc := &tlsConn{}
+	config, err := tc.offloadConfig(base, &tls.ClientHelloInfo{SupportedVersions: []uint16{tls.VersionTLS12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != base || tc.secrets != nil {
+		t.Fatal("expect the config of a TLS 1.2 client to be left alone")
+	}
+
+	config, err = tc.offloadConfig(base, &tls.ClientHelloInfo{SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.SessionTicketsDisabled || tc.secrets == nil || config.KeyLogWriter != tc.secrets {
+		t.Fatal("expect the session of a TLS 1.3 client to be prepared for the offload")
+	}
+	if base.SessionTicketsDisabled || base.KeyLogWriter != nil {
+		t.Fatal("the config which is shared by the connections is modified")
+	}
+
+	// the config picked for the client decides, a config limited to TLS 1.2 is never offloaded.
+	tc = &tlsConn{}
+	picked := &tls.Config{MaxVersion: tls.VersionTLS12}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) { return picked, nil }
+	config, err = tc.offloadConfig(base, &tls.ClientHelloInfo{SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != picked || tc.secrets != nil {
+		t.Fatal("expect the config limited to TLS 1.2 to be left alone")
+	}
+}
+
+// ktlsServer echoes and reports whether the kernel has taken over both directions of the TLS session.
+type ktlsServer struct {
+	echoServer
+
+	offloaded chan bool
+}
+
+func (ks *ktlsServer) OnOpen(c Conn) ([]byte, Action) {
+	t := c.(*conn).tls
+	ks.offloaded <- t != nil && t.ktx && t.krx
+	return []byte("hello ktls"), None
+}
+
+func TestKTLSOffload(t *testing.T) {
+	if _, err := os.Stat("/sys/module/tls"); err != nil {
+		t.Skip("the kernel has no TLS module")
+	}
+	cert := selfSignedCert(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	handler := &ktlsServer{offloaded: make(chan bool, 1)}
+	_, addr := startEngineWithOptions(t, socket.Tcp4, "127.0.0.1:0", 1, socket.SocketOptions{}, handler,
+		func(ringNet *URingNet) {
+			ringNet.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		})
+
+	raw := dial(t, "tcp", addr)
+	client := tls.Client(raw, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS13})
+	defer client.Close()
+
+	greeting := make([]byte, len("hello ktls"))
+	if _, err := io.ReadFull(client, greeting); err != nil {
+		t.Fatal(err)
+	}
+	if string(greeting) != "hello ktls" {
+		t.Fatalf("expect the greeting %q, but got %q", "hello ktls", greeting)
+	}
+	if !<-handler.offloaded {
+		t.Fatal("expect the session to be offloaded to the kernel")
+	}
+	// the records are sealed and opened by the kernel from now on.
+	expectEcho(t, client, []byte("hello"))
+	expectEcho(t, client, bytes.Repeat([]byte("0123456789"), 10000))
+}
+
+// recordConn keeps the bytes written to it once record is set.
+type recordConn struct {
+	net.Conn
+
+	record  int32
+	written bytes.Buffer
+}
+
+func (rc *recordConn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&rc.record) == 1 {
+		rc.written.Write(p)
+	}
+	return rc.Conn.Write(p)
+}
+
+func TestKTLSKeys(t *testing.T) {
+	cert := selfSignedCert(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	secrets := &tlsSecrets{}
+	serverSide, clientSide := net.Pipe()
+	recorder := &recordConn{Conn: clientSide}
+	server := tls.Server(serverSide, &tls.Config{Certificates: []tls.Certificate{cert}, KeyLogWriter: secrets, SessionTicketsDisabled: true})
+	client := tls.Client(recorder, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS13})
+	// the pipe is closed directly, the close_notify alert would wait for a reader.
+	defer serverSide.Close()
+	defer clientSide.Close()
+
+	go func() {
+		if err := server.Handshake(); err == nil {
+			_, _ = server.Read(make([]byte, 16))
+		}
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&recorder.record, 1)
+	if _, err := client.Write([]byte("hello ktls")); err != nil {
+		t.Fatal(err)
+	}
+
+	state := client.ConnectionState()
+	tx, rx, ok := secrets.cryptoInfo(state.CipherSuite)
+	if !ok {
+		t.Fatalf("expect the keys of %s", tls.CipherSuiteName(state.CipherSuite))
+	}
+	if len(tx.Key) != len(rx.Key) || bytes.Equal(tx.Key, rx.Key) {
+		t.Fatal("expect distinct keys for both directions")
+	}
+	if state.CipherSuite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		t.Skip("ChaCha20-Poly1305 is not in the standard library")
+	}
+
+	// the first record the client sends after the handshake is sealed with the key the server receives with.
+	record := recorder.written.Bytes()
+	block, err := aes.NewCipher(rx.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := aead.Open(nil, rx.IV, record[5:], record[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the inner plaintext ends with the type of its content, application data.
+	if want := "hello ktls\x17"; string(plaintext) != want {
+		t.Fatalf("expect %q, but got %q", want, plaintext)
+	}
+}
+
+// lineServer echoes every complete line and leaves a partial line in the inbound buffer.
+type lineServer struct {
+	BuiltinEventEngine
+}
+
+func (ls *lineServer) OnTraffic(c Conn) Action {
+	for {
+		buf, _ := c.Peek(-1)
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		line, _ := c.Next(i + 1)
+		_, _ = c.Write(line)
+	}
+	return Echo
+}
+
+func TestInboundBuffer(t *testing.T) {
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, &lineServer{})
+
+	c1 := dial(t, "tcp", addr)
+	defer c1.Close()
+	c2 := dial(t, "tcp", addr)
+	defer c2.Close()
+
+	// fragments of both connections are interleaved, every line must stay in its own connection.
+	for _, part := range []string{"hel", "lo\nwo", "rld", "\n"} {
+		if _, err := c1.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c2.Write([]byte(strings.ToUpper(part))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for c, want := range map[net.Conn]string{c1: "hello\nworld\n", c2: "HELLO\nWORLD\n"} {
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("expect %q, but got %q", want, got)
+		}
+	}
+}
+
+// frameServer answers every frame with the frame in upper case, each OnTraffic must see exactly one frame.
+type frameServer struct {
+	BuiltinEventEngine
+
+	frames chan string
+}
+
+func (fs *frameServer) OnTraffic(c Conn) Action {
+	frame, _ := c.Next(-1)
+	fs.frames <- string(frame)
+	_, _ = c.Write(bytes.ToUpper(frame))
+	return Echo
+}
+
+func TestCodec(t *testing.T) {
+	codec, err := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{LengthFieldLength: 2},
+		DecoderConfig{LengthFieldLength: 2, InitialBytesToStrip: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &frameServer{frames: make(chan string, 8)}
+	_, addr := startEngineWithOptions(t, socket.Tcp4, "127.0.0.1:0", 1,
+		socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}, handler,
+		func(ringNet *URingNet) { ringNet.Codec = codec })
+
+	c := dial(t, "tcp", addr)
+	defer c.Close()
+
+	// two frames arrive in one read, the third one is split across reads, its header included.
+	for _, part := range []string{"\x00\x05hello\x00\x01,", "\x00", "\x05wor", "ld"} {
+		if _, err := c.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, want := range []string{"hello", ",", "world"} {
+		select {
+		case frame := <-handler.frames:
+			if frame != want {
+				t.Fatalf("expect frame %q, but got %q", want, frame)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("frame %q is not decoded", want)
+		}
+	}
+	want := "\x00\x05HELLO\x00\x01,\x00\x05WORLD"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("expect %q, but got %q", want, got)
+	}
+}
+
+func TestFrameCodecs(t *testing.T) {
+	delimiter, _ := NewDelimiterBasedFrameCodec([]byte("||"))
+	fixed, _ := NewFixedLengthFrameCodec(3)
+	le4, _ := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 4},
+		DecoderConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 4, InitialBytesToStrip: 4})
+	// the 8-byte length field follows a 1-byte tag and counts the whole frame.
+	be8, _ := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{LengthFieldLength: 8, LengthIncludesLengthFieldLength: true},
+		DecoderConfig{LengthFieldOffset: 1, LengthFieldLength: 8, LengthAdjustment: -9})
+	for _, tc := range []struct {
+		name    string
+		codec   Codec
+		msg     string
+		encoded string
+		input   string
+		frames  []string
+		n       int
+	}{
+		{"delimiter", delimiter, "ab", "ab||", "a||bc||d", []string{"a", "bc"}, 7},
+		{"line", &LineBasedFrameCodec{}, "ab", "ab\n", "a\r\nbc\nd", []string{"a", "bc"}, 6},
+		{"fixed", fixed, "abc", "abc", "abcdefg", []string{"abc", "def"}, 6},
+		{"length 4 little endian", le4, "ab", "\x02\x00\x00\x00ab", "\x01\x00\x00\x00a\x02\x00\x00\x00b", []string{"a"}, 5},
+		{"length 8 big endian", be8, "ab", "\x00\x00\x00\x00\x00\x00\x00\x0aab", "t\x00\x00\x00\x00\x00\x00\x00\x0aab", []string{"t\x00\x00\x00\x00\x00\x00\x00\x0aa"}, 10},
+	} {
+		encoded, err := tc.codec.Encode(nil, nil, []byte(tc.msg))
+		if err != nil || string(encoded) != tc.encoded {
+			t.Fatalf("%s: expect %q to be encoded into %q, but got %q, %v", tc.name, tc.msg, tc.encoded, encoded, err)
+		}
+		frames, n, err := tc.codec.Decode(nil, []byte(tc.input))
+		if err != nil || n != tc.n || len(frames) != len(tc.frames) {
+			t.Fatalf("%s: expect %d frames of %d bytes, but got %q of %d bytes, %v", tc.name, len(tc.frames), tc.n, frames, n, err)
+		}
+		for i, frame := range frames {
+			if string(frame) != tc.frames[i] {
+				t.Fatalf("%s: expect frame %q, but got %q", tc.name, tc.frames[i], frame)
+			}
+		}
+	}
+
+	if _, err := fixed.Encode(nil, nil, []byte("ab")); err != uerrors.ErrUnexpectedFrameLength {
+		t.Fatalf("expect %v, but got %v", uerrors.ErrUnexpectedFrameLength, err)
+	}
+	if _, _, err := le4.Decode(nil, []byte("\xff\xff\xff\xff")); err != uerrors.ErrTooLargeLength {
+		t.Fatalf("expect %v, but got %v", uerrors.ErrTooLargeLength, err)
+	}
+	if _, _, err := be8.Decode(nil, []byte("t\x00\x00\x00\x00\x00\x00\x00\x01")); err != uerrors.ErrTooLessLength {
+		t.Fatalf("expect %v, but got %v", uerrors.ErrTooLessLength, err)
+	}
+	if _, err := NewLengthFieldBasedFrameCodec(EncoderConfig{LengthFieldLength: 3}, DecoderConfig{LengthFieldLength: 2}); err != uerrors.ErrUnsupportedLength {
+		t.Fatalf("expect %v, but got %v", uerrors.ErrUnsupportedLength, err)
+	}
+}
+
+func TestNextShortBuffer(t *testing.T) {
+	c := &conn{}
+	c.feed([]byte("hello"))
+	if buf, err := c.Next(2); err != nil || string(buf) != "he" {
+		t.Fatalf("expect %q, but got %q, %v", "he", buf, err)
+	}
+	// more bytes are asked for than are buffered, the rest of the buffer is returned.
+	if buf, err := c.Next(10); err != nil || string(buf) != "llo" {
+		t.Fatalf("expect %q, but got %q, %v", "llo", buf, err)
+	}
+	if n := c.InboundBuffered(); n != 0 {
+		t.Fatalf("expect the buffer to be consumed, but %d bytes are left", n)
+	}
+}
+
+// bulkServer answers every request with a large payload written in many pieces.
+type bulkServer struct {
+	BuiltinEventEngine
+
+	pieces [][]byte
+}
+
+func (bs *bulkServer) OnTraffic(c Conn) Action {
+	_, _ = c.Discard(-1)
+	for _, p := range bs.pieces {
+		_, _ = c.Write(p)
+	}
+	return Echo
+}
+
+func TestOutboundShortWrite(t *testing.T) {
+	handler := &bulkServer{}
+	var want []byte
+	for i := 0; i < 64; i++ {
+		p := bytes.Repeat([]byte{byte('a' + i%26)}, 64*1024+i)
+		handler.pieces = append(handler.pieces, p)
+		want = append(want, p...)
+	}
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Write([]byte("go")); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatal("the payload is corrupted")
+		}
+	}
+}
+
+func TestZeroCopySend(t *testing.T) {
+	handler := &bulkServer{}
+	var want []byte
+	for i := 0; i < 8; i++ {
+		// pieces on both sides of the threshold, so that copied and zero-copy sends interleave.
+		p := bytes.Repeat([]byte{byte('a' + i)}, zeroCopyThreshold/2+i*zeroCopyThreshold/3)
+		handler.pieces = append(handler.pieces, p)
+		want = append(want, p...)
+	}
+	supported := true
+	loop, addr := startEngineWithOptions(t, socket.Tcp4, "127.0.0.1:0", 1, socket.SocketOptions{}, handler,
+		func(ringNet *URingNet) {
+			supported = ringNet.features.sendZC
+		})
+	if !supported {
+		t.Skip("zero-copy send is not supported by the kernel")
+	}
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+	for i := 0; i < 4; i++ {
+		if _, err := client.Write([]byte("go")); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatal("the payload is corrupted")
+		}
+	}
+
+	// the shutdown waits for the notifications of the zero-copy sends.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := loop.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultishotRecv(t *testing.T) {
+	ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 64, false, 1, socket.SocketOptions{}, &echoServer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// far fewer buffers than the payload needs, they have to be recycled while it streams in.
+	loop := SetLoops(ringNets, 16)
+	if loop == nil {
+		t.Fatal("failed to set the ring loops")
+	}
+	if ringNets[0].bufRing == nil || !ringNets[0].features.multishotRecv {
+		t.Skip("multishot receive with a buffer ring is not supported by the kernel")
+	}
+	sa, err := unix.Getsockname(ringNets[0].SocketFd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the rings run by RunMany2 read into the provided buffers, which multishot receive needs.
+	loop.RunMany2()
+	defer loop.Shutdown(context.Background())
+
+	client := dial(t, "tcp", socket.SockaddrToTCPOrUnixAddr(sa).String())
+	defer client.Close()
+	// the payload spans 512 buffers of 2 KiB.
+	want := make([]byte, 1<<20)
+	for i := range want {
+		want[i] = byte(i % 251)
+	}
+	go func() {
+		_, _ = client.Write(want)
+	}()
+	got := make([]byte, len(want))
+	if _, err = io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("the payload is corrupted")
+	}
+}
+
+// fileServer answers every request with a header, a range of its file and a trailer.
+type fileServer struct {
+	BuiltinEventEngine
+
+	file         fs.File
+	offset, size int64
+	written      chan struct{}
+}
+
+func (fsrv *fileServer) OnTraffic(c Conn) Action {
+	_, _ = c.Discard(-1)
+	_, _ = c.Write([]byte("header\n"))
+	if err := c.SendFile(fsrv.file, fsrv.offset, fsrv.size); err != nil {
+		return Close
+	}
+	_, _ = c.Write([]byte("trailer\n"))
+	return Echo
+}
+
+func (fsrv *fileServer) OnWritten(c Conn) Action {
+	fsrv.written <- struct{}{}
+	return None
+}
+
+func TestSendFile(t *testing.T) {
+	content := make([]byte, 3<<20)
+	for i := range content {
+		content[i] = byte(i % 253)
+	}
+	f, err := os.CreateTemp(t.TempDir(), "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	mapFile, err := fstest.MapFS{"blob": {Data: content}}.Open("blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		file         fs.File
+		offset, size int64
+	}{
+		{"spliced", f, 1000, 2 << 20},
+		{"spliced to the end", f, 1 << 20, 0},
+		{"copied", mapFile, 1000, 1 << 20},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &fileServer{file: tc.file, offset: tc.offset, size: tc.size, written: make(chan struct{}, 1)}
+			_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+			client := dial(t, "tcp", addr)
+			defer client.Close()
+
+			end := int64(len(content))
+			if tc.size > 0 {
+				end = tc.offset + tc.size
+			}
+			want := append([]byte("header\n"), content[tc.offset:end]...)
+			want = append(want, "trailer\n"...)
+			for i := 0; i < 2; i++ {
+				if _, err := client.Write([]byte("get")); err != nil {
+					t.Fatal(err)
+				}
+				got := make([]byte, len(want))
+				if _, err := io.ReadFull(client, got); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatal("the file is corrupted")
+				}
+				select {
+				case <-handler.written:
+				case <-time.After(5 * time.Second):
+					t.Fatal("OnWritten was not fired")
+				}
+			}
+		})
+	}
+}
+
+// writevServer answers every request with a header, a body and a trailer written by one Writev.
+type writevServer struct {
+	BuiltinEventEngine
+
+	parts [][]byte
+}
+
+func (ws *writevServer) OnTraffic(c Conn) Action {
+	_, _ = c.Discard(-1)
+	_, _ = c.Writev(ws.parts)
+	return Echo
+}
+
+func TestWritev(t *testing.T) {
+	handler := &writevServer{}
+	for i := 0; i < 3*maxSendIovecs; i++ {
+		// parts larger than the minimum size of the outbound buffers aren't coalesced.
+		handler.parts = append(handler.parts, bytes.Repeat([]byte{byte('a' + i%26)}, minOutboundSize+i))
+	}
+	want := bytes.Join(handler.parts, nil)
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := client.Write([]byte("go")); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatal("the gathered parts are corrupted")
+		}
+	}
+}
+
+// asyncServer hands every connection to the test goroutine and counts the OnTraffic events.
+type asyncServer struct {
+	BuiltinEventEngine
+
+	opened  chan Conn
+	traffic chan int
+}
+
+func (as *asyncServer) OnOpen(c Conn) ([]byte, Action) {
+	as.opened <- c
+	return nil, None
+}
+
+func (as *asyncServer) OnTraffic(c Conn) Action {
+	as.traffic <- c.InboundBuffered()
+	return Read
+}
+
+func TestAsyncWriteAndWake(t *testing.T) {
+	handler := &asyncServer{opened: make(chan Conn, 1), traffic: make(chan int, 1)}
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+	c := <-handler.opened
+
+	called := make(chan struct{})
+	go func() {
+		err := c.AsyncWritev([][]byte{[]byte("hello "), []byte("async")}, func(_ Conn, err error) error {
+			if err != nil {
+				t.Error(err)
+			}
+			close(called)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	got := make([]byte, len("hello async"))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello async" {
+		t.Fatalf("expect %q, but got %q", "hello async", got)
+	}
+	<-called
+
+	if err := c.Wake(nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-handler.traffic:
+		if n != 0 {
+			t.Fatalf("expect nothing is buffered on wake, but got %d bytes", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnTraffic was not fired by Wake")
+	}
+
+	if err := c.Close(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(got); err != io.EOF {
+		t.Fatalf("expect EOF after Close, but got %v", err)
+	}
+
+	// the callbacks are still invoked once the connection is closed, and learn that nothing was done.
+	errs := make(chan error, 4)
+	callback := func(_ Conn, err error) error {
+		errs <- err
+		return nil
+	}
+	for _, f := range []func() error{
+		func() error { return c.AsyncWrite([]byte("late"), callback) },
+		func() error { return c.AsyncWritev([][]byte{[]byte("late")}, callback) },
+		func() error { return c.Wake(callback) },
+		func() error { return c.Close(callback) },
+	} {
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errs:
+			if !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("expect %v, but got %v", net.ErrClosed, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the callback was not invoked for a closed connection")
+		}
+	}
+}
+
+// tickServer shuts the engine down after every ring has ticked a few times.
+type tickServer struct {
+	BuiltinEventEngine
+
+	ticks    int32
+	shutdown chan struct{}
+}
+
+func (ts *tickServer) OnTick() (time.Duration, Action) {
+	if atomic.AddInt32(&ts.ticks, 1) > 10 {
+		return 0, Shutdown
+	}
+	return 10 * time.Millisecond, None
+}
+
+func (ts *tickServer) OnShutdown(_ *URingNet) {
+	close(ts.shutdown)
+}
+
+func TestTick(t *testing.T) {
+	handler := &tickServer{shutdown: make(chan struct{})}
+	options := socket.SocketOptions{ReusePort: true, Ticker: true}
+	loop, _ := startEngineWithOptions(t, socket.Tcp4, "127.0.0.1:0", 2, options, handler)
+
+	select {
+	case <-handler.shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the engine was not shut down by OnTick")
+	}
+	for _, ringNet := range loop.RingNet {
+		select {
+		case <-ringNet.done:
+		default:
+			t.Fatal("a ring is still running after OnShutdown")
+		}
+	}
+}
+
+// timeoutServer echoes and reports why its connections are closed.
+type timeoutServer struct {
+	echoServer
+
+	deadline time.Duration
+	closed   chan error
+}
+
+func (ts *timeoutServer) OnOpen(c Conn) ([]byte, Action) {
+	if ts.deadline > 0 {
+		_ = c.SetReadDeadline(time.Now().Add(ts.deadline))
+	}
+	return nil, None
+}
+
+func (ts *timeoutServer) OnClose(_ Conn, err error) Action {
+	ts.closed <- err
+	return None
+}
+
+func TestIdleTimeout(t *testing.T) {
+	handler := &timeoutServer{closed: make(chan error, 1)}
+	options := socket.SocketOptions{TCPNoDelay: socket.TCPNoDelay, ReusePort: true}
+	_, addr := startEngineWithOptions(t, socket.Tcp4, "127.0.0.1:0", 1, options, handler, func(ringNet *URingNet) {
+		ringNet.ReadHeaderTimeout = time.Second
+		ringNet.IdleTimeout = 100 * time.Millisecond
+	})
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+
+	// the connection survives while it is active, even though it is active for longer than the idle timeout.
+	for i := 0; i < 5; i++ {
+		expectEcho(t, client, []byte("ping"))
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case err := <-handler.closed:
+		if !errors.Is(err, uerrors.ErrTimeout) {
+			t.Fatalf("expect a timeout error, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the idle connection was not closed")
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect EOF after the idle timeout, but got %v", err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	handler := &timeoutServer{deadline: 100 * time.Millisecond, closed: make(chan error, 1)}
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+
+	start := time.Now()
+	select {
+	case err := <-handler.closed:
+		if !errors.Is(err, uerrors.ErrTimeout) {
+			t.Fatalf("expect a timeout error, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed at the read deadline")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("the connection was closed too early, after %v", elapsed)
+	}
+}
+
+// lateDeadlineServer echoes and sets the read deadline only once the connection has sent something, when the
+// read has been armed already.
+type lateDeadlineServer struct {
+	timeoutServer
+}
+
+func (ls *lateDeadlineServer) OnTraffic(c Conn) Action {
+	_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	return ls.timeoutServer.OnTraffic(c)
+}
+
+func TestReadDeadlineAfterRead(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		run  func(*Ringloop)
+	}{
+		{"RunMany", (*Ringloop).RunMany},
+		// the read of RunMany2 is a multishot receive, which has to be canceled to apply the deadline.
+		{"RunMany2", (*Ringloop).RunMany2},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &lateDeadlineServer{timeoutServer{closed: make(chan error, 1)}}
+			ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 64, false, 1, socket.SocketOptions{}, handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loop := SetLoops(ringNets, 64)
+			if loop == nil {
+				t.Fatal("failed to set the ring loops")
+			}
+			sa, err := unix.Getsockname(ringNets[0].SocketFd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.run(loop)
+			defer loop.Shutdown(context.Background())
+
+			client := dial(t, "tcp", socket.SockaddrToTCPOrUnixAddr(sa).String())
+			defer client.Close()
+			expectEcho(t, client, []byte("hello"))
+			select {
+			case err := <-handler.closed:
+				if !errors.Is(err, uerrors.ErrTimeout) {
+					t.Fatalf("expect a timeout error, but got %v", err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("the connection was not closed at the read deadline")
+			}
+		})
+	}
+}
+
+// shutdownServer answers every request with a large payload and reports why its connections are closed.
+type shutdownServer struct {
+	bulkServer
+
+	traffic chan struct{}
+	closed  chan error
+	stopped chan struct{}
+}
+
+func (ss *shutdownServer) OnTraffic(c Conn) Action {
+	action := ss.bulkServer.OnTraffic(c)
+	ss.traffic <- struct{}{}
+	return action
+}
+
+func (ss *shutdownServer) OnClose(_ Conn, err error) Action {
+	ss.closed <- err
+	return None
+}
+
+func (ss *shutdownServer) OnShutdown(_ *URingNet) {
+	close(ss.stopped)
+}
+
+func TestShutdown(t *testing.T) {
+	handler := &shutdownServer{
+		bulkServer: bulkServer{pieces: [][]byte{bytes.Repeat([]byte("x"), 4<<20)}},
+		traffic:    make(chan struct{}, 1),
+		closed:     make(chan error, 2),
+		stopped:    make(chan struct{}),
+	}
+	loop, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 2, handler)
+
+	idle := dial(t, "tcp", addr)
+	defer idle.Close()
+	busy := dial(t, "tcp", addr)
+	defer busy.Close()
+	if _, err := busy.Write([]byte("go")); err != nil {
+		t.Fatal(err)
+	}
+	<-handler.traffic
+
+	// the payload is pending while the client doesn't read, the shutdown has to wait for it.
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- loop.Shutdown(ctx)
+	}()
+	if err := <-handler.closed; !errors.Is(err, uerrors.ErrEngineShutdown) {
+		t.Fatalf("expect the idle connection to be closed by the shutdown, but got %v", err)
+	}
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect EOF on the idle connection, but got %v", err)
+	}
+
+	n, err := io.Copy(io.Discard, busy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4<<20 {
+		t.Fatalf("expect the pending %d bytes to be drained, but got %d", 4<<20, n)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handler.stopped:
+	default:
+		t.Fatal("OnShutdown was not fired")
+	}
+	if err := loop.Shutdown(context.Background()); err != uerrors.ErrEngineInShutdown {
+		t.Fatalf("expect %v, but got %v", uerrors.ErrEngineInShutdown, err)
+	}
+	if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		c.Close()
+		t.Fatal("the listener is still open after the shutdown")
+	}
+}
+
+func TestShutdownManyConnections(t *testing.T) {
+	// every close takes 2 SQEs, the connections don't fit into the SQ of 64 entries at once.
+	const conns = 100
+	handler := &echoServer{opened: make(chan Conn, conns)}
+	loop, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	clients := make([]net.Conn, conns)
+	for i := range clients {
+		clients[i] = dial(t, "tcp", addr)
+		defer clients[i].Close()
+		<-handler.opened
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := loop.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expect EOF, but got %v", err)
+		}
+	}
+}
+
+func TestShutdownNotRunning(t *testing.T) {
+	ringNets, err := NewMany(NetAddress{AddrType: socket.Tcp4, Address: "127.0.0.1:0"}, 64, false, 2, socket.SocketOptions{}, &echoServer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := SetLoops(ringNets, 64)
+	if loop == nil {
+		t.Fatal("failed to set the ring loops")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := loop.Shutdown(ctx); err != nil {
+		t.Fatalf("expect the rings which never ran not to be waited for, but got %v", err)
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	handler := &timeoutServer{closed: make(chan error, 1)}
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	client := dial(t, "tcp", addr)
+	expectEcho(t, client, []byte("bye"))
+	client.Close()
+	if err := <-handler.closed; err != io.EOF {
+		t.Fatalf("expect EOF when the peer closes, but got %v", err)
+	}
+
+	client = dial(t, "tcp", addr)
+	expectEcho(t, client, []byte("reset"))
+	// closing with a zero linger resets the connection.
+	_ = client.(*net.TCPConn).SetLinger(0)
+	client.Close()
+	if err := <-handler.closed; !errors.Is(err, unix.ECONNRESET) {
+		t.Fatalf("expect ECONNRESET when the peer resets, but got %v", err)
+	}
+
+	// only the cancel of an operation whose timeout is linked means the timeout has expired.
+	canceled := -int32(unix.ECANCELED)
+	if err := resultError("recv", canceled, true); err != uerrors.ErrTimeout {
+		t.Fatalf("expect %v, but got %v", uerrors.ErrTimeout, err)
+	}
+	if err := resultError("recv", canceled, false); !errors.Is(err, unix.ECANCELED) {
+		t.Fatalf("expect ECANCELED, but got %v", err)
+	}
+}
+
+// greetServer greets every connection, rejects the ones it is told to and answers in two steps:
+// OnTraffic writes, OnWritten reads again or closes the connection after a "quit".
+type greetServer struct {
+	BuiltinEventEngine
+
+	reject  int32
+	quit    bool
+	closed  chan error
+	stopped chan struct{}
+}
+
+func (gs *greetServer) OnOpen(c Conn) ([]byte, Action) {
+	if atomic.LoadInt32(&gs.reject) == 1 {
+		return []byte("go away\r\n"), Close
+	}
+	return []byte("220 ready\r\n"), None
+}
+
+func (gs *greetServer) OnTraffic(c Conn) Action {
+	buf, _ := c.Next(-1)
+	gs.quit = string(buf) == "quit\r\n"
+	_, _ = c.Write(buf)
+	return Write
+}
+
+func (gs *greetServer) OnWritten(c Conn) Action {
+	if gs.quit {
+		return Close
+	}
+	return Read
+}
+
+func (gs *greetServer) OnClose(_ Conn, err error) Action {
+	gs.closed <- err
+	if gs.quit {
+		return Shutdown
+	}
+	return None
+}
+
+func (gs *greetServer) OnShutdown(_ *URingNet) {
+	close(gs.stopped)
+}
+
+func TestOpenAndWrittenActions(t *testing.T) {
+	handler := &greetServer{closed: make(chan error, 1), stopped: make(chan struct{})}
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	client := dial(t, "tcp", addr)
+	defer client.Close()
+	greeting := make([]byte, len("220 ready\r\n"))
+	if _, err := io.ReadFull(client, greeting); err != nil {
+		t.Fatal(err)
+	}
+	if string(greeting) != "220 ready\r\n" {
+		t.Fatalf("expect the greeting, but got %q", greeting)
+	}
+	expectEcho(t, client, []byte("helo\r\n"))
+	expectEcho(t, client, []byte("helo again\r\n"))
+
+	atomic.StoreInt32(&handler.reject, 1)
+	rejected := dial(t, "tcp", addr)
+	defer rejected.Close()
+	if n, err := rejected.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("expect the connection to be rejected without a greeting, but got %d bytes and %v", n, err)
+	}
+	if err := <-handler.closed; err != nil {
+		t.Fatalf("expect the rejected connection to be closed by the action, but got %v", err)
+	}
+
+	expectEcho(t, client, []byte("quit\r\n"))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect EOF after quit, but got %v", err)
+	}
+	if err := <-handler.closed; err != nil {
+		t.Fatalf("expect the connection to be closed by OnWritten, but got %v", err)
+	}
+	select {
+	case <-handler.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the engine was not shut down by OnClose")
+	}
+}
+
+// contextServer counts the requests of every connection in the connection's own context.
+type contextServer struct {
+	BuiltinEventEngine
+
+	closed chan int
+}
+
+func (cs *contextServer) OnOpen(c Conn) ([]byte, Action) {
+	c.SetContext(new(int))
+	return nil, None
+}
+
+func (cs *contextServer) OnTraffic(c Conn) Action {
+	n := c.Context().(*int)
+	_, _ = c.Discard(-1)
+	*n++
+	_, _ = c.Write([]byte{byte('0' + *n)})
+	return Echo
+}
+
+func (cs *contextServer) OnClose(c Conn, _ error) Action {
+	cs.closed <- *c.Context().(*int)
+	return None
+}
+
+func TestConnContext(t *testing.T) {
+	handler := &contextServer{closed: make(chan int, 1)}
+	_, addr := startEngine(t, socket.Tcp4, "127.0.0.1:0", 1, handler)
+
+	c1 := dial(t, "tcp", addr)
+	defer c1.Close()
+	c2 := dial(t, "tcp", addr)
+	defer c2.Close()
+
+	expect := func(c net.Conn, want byte) {
+		t.Helper()
+		if _, err := c.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 1)
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		if got[0] != want {
+			t.Fatalf("expect count %c, but got %c", want, got[0])
+		}
+	}
+	expect(c1, '1')
+	expect(c1, '2')
+	expect(c2, '1')
+	expect(c1, '3')
+
+	c2.Close()
+	if n := <-handler.closed; n != 1 {
+		t.Fatalf("expect the context to survive until OnClose with count 1, but got %d", n)
+	}
+}
+
+// datagramServer answers every datagram with two datagrams and reports the senders.
+type datagramServer struct {
+	BuiltinEventEngine
+
+	senders chan string
+}
+
+func (ds *datagramServer) OnTraffic(c Conn) Action {
+	ds.senders <- c.RemoteAddr().String()
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	_, _ = c.Writev([][]byte{[]byte("from "), []byte(c.LocalAddr().String())})
+	return Write
+}
+
+func TestDatagram(t *testing.T) {
+	handler := &datagramServer{senders: make(chan string, 4)}
+	options := socket.SocketOptions{ReusePort: true}
+	_, addr := startEngineWithOptions(t, socket.Udp4, "127.0.0.1:0", 2, options, handler)
+
+	for i := 0; i < 2; i++ {
+		client := dial(t, "udp", addr)
+		defer client.Close()
+		msg := []byte(strings.Repeat("datagram", i+1))
+		if _, err := client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 1024)
+		n, err := client.Read(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got[:n], msg) {
+			t.Fatalf("expect the first reply %q, but got %q", msg, got[:n])
+		}
+		if n, err = client.Read(got); err != nil {
+			t.Fatal(err)
+		}
+		if want := "from " + addr; string(got[:n]) != want {
+			t.Fatalf("expect the second reply %q, but got %q", want, got[:n])
+		}
+		if sender := <-handler.senders; sender != client.LocalAddr().String() {
+			t.Fatalf("expect the sender %s, but got %s", client.LocalAddr(), sender)
+		}
+	}
+}
+
+// dialClient greets the peer it is dialed to and reports the bytes sent back.
+type dialClient struct {
+	BuiltinEventEngine
+
+	traffic chan string
+	closed  chan error
+}
+
+func (dc *dialClient) OnOpen(c Conn) ([]byte, Action) {
+	return []byte("hi"), None
+}
+
+func (dc *dialClient) OnTraffic(c Conn) Action {
+	buf, _ := c.Next(-1)
+	dc.traffic <- string(buf)
+	return Read
+}
+
+func (dc *dialClient) OnClose(_ Conn, err error) Action {
+	dc.closed <- err
+	return None
+}
+
+func TestDialUDP(t *testing.T) {
+	peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	_ = peer.SetDeadline(time.Now().Add(5 * time.Second))
+
+	handler := &dialClient{traffic: make(chan string, 1), closed: make(chan error, 1)}
+	loop, _ := startEngine(t, socket.Tcp4, "127.0.0.1:0", 2, handler)
+	c, err := loop.DialUDP("udp4", peer.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 16)
+	n, from, err := peer.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hi" {
+		t.Fatalf("expect the greeting, but got %q", buf[:n])
+	}
+	if from.String() != c.LocalAddr().String() {
+		t.Fatalf("expect the greeting from %s, but got it from %s", c.LocalAddr(), from)
+	}
+	if _, err = peer.WriteToUDP([]byte("pong"), from); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-handler.traffic:
+		if got != "pong" {
+			t.Fatalf("expect %q, but got %q", "pong", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the datagram sent back was not received")
+	}
+
+	if err = loop.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-handler.closed; !errors.Is(err, uerrors.ErrEngineShutdown) {
+		t.Fatalf("expect the socket to be closed by the shutdown, but got %v", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	peer, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	go func() {
+		pc, err := peer.Accept()
+		if err != nil {
+			return
+		}
+		defer pc.Close()
+		_, _ = io.Copy(pc, pc)
+	}()
+
+	handler := &dialClient{traffic: make(chan string, 1), closed: make(chan error, 1)}
+	loop, _ := startEngine(t, socket.Tcp4, "127.0.0.1:0", 2, handler)
+	c, err := loop.DialTimeout("tcp4", peer.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoteAddr().String() != peer.Addr().String() {
+		t.Fatalf("expect the connection to %s, but got %s", peer.Addr(), c.RemoteAddr())
+	}
+	select {
+	case got := <-handler.traffic:
+		if got != "hi" {
+			t.Fatalf("expect the greeting to be echoed, but got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the greeting was not echoed")
+	}
+
+	// nothing listens on the address of the closed listener.
+	refused, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = refused.Close()
+	if _, err = loop.Dial("tcp4", refused.Addr().String()); !errors.Is(err, unix.ECONNREFUSED) {
+		t.Fatalf("expect the connection to be refused, but got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = loop.DialContext(ctx, "tcp4", peer.Addr().String()); err != context.Canceled {
+		t.Fatalf("expect the dial to be canceled, but got %v", err)
+	}
+
+	if err = loop.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-handler.closed; !errors.Is(err, uerrors.ErrEngineShutdown) {
+		t.Fatalf("expect the connection to be closed by the shutdown, but got %v", err)
+	}
+}
+
+// credServer echoes and reports the credentials of its peers.
+type credServer struct {
+	echoServer
+
+	creds chan *unix.Ucred
+}
+
+func (cs *credServer) OnOpen(c Conn) ([]byte, Action) {
+	cred, err := c.PeerCred()
+	if err != nil {
+		panic(err)
+	}
+	cs.creds <- cred
+	return nil, None
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "echo.sock")
+	// a socket file left by a former server which didn't clean up.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	handler := &credServer{creds: make(chan *unix.Ucred, 3)}
+	options := socket.SocketOptions{UnixSocketMode: 0o600}
+	loop, addr := startEngineWithOptions(t, socket.Unix, path, 2, options, handler)
+	if addr != path {
+		t.Fatalf("expect to listen on %s, but got %s", path, addr)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("expect the socket file mode %v, but got %v", os.FileMode(0o600), perm)
+	}
+
+	client := dial(t, "unix", path)
+	defer client.Close()
+	for i := 0; i < 10; i++ {
+		expectEcho(t, client, []byte("hello unix"))
+	}
+	cred := <-handler.creds
+	if int(cred.Pid) != os.Getpid() || int(cred.Uid) != os.Getuid() || int(cred.Gid) != os.Getgid() {
+		t.Fatalf("expect the credentials of this process, but got %+v", cred)
+	}
+	// the engine dials its own socket by the path.
+	if _, err = loop.Dial("unix", path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = loop.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expect the socket file to be removed by the shutdown, but got %v", err)
+	}
+}
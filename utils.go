@@ -12,62 +12,62 @@ import (
 
 var zero uintptr
 
-func bytes2iovec(bs [][]byte) []unix.Iovec {
-	iovecs := make([]unix.Iovec, len(bs))
-	for i, b := range bs {
-		iovecs[i].SetLen(len(b))
+// bytes2iovec appends an iovec for every byte slice of bs to iovecs.
+func bytes2iovec(iovecs []unix.Iovec, bs [][]byte) []unix.Iovec {
+	for _, b := range bs {
+		var iov unix.Iovec
+		iov.SetLen(len(b))
 		if len(b) > 0 {
-			iovecs[i].Base = &b[0]
+			iov.Base = &b[0]
 		} else {
-			iovecs[i].Base = (*byte)(unsafe.Pointer(&zero))
+			iov.Base = (*byte)(unsafe.Pointer(&zero))
 		}
+		iovecs = append(iovecs, iov)
 	}
 	return iovecs
 }
 
 type Socklen uint
 
-// SockaddrToAny converts a Sockaddr to a RawSockaddrAny.
+// sockaddrToAny converts a Sockaddr to a RawSockaddrAny. The address is written into a RawSockaddrAny of its
+// own, which is large enough to be copied as a whole, e.g. into the connection whose connect reads it.
 func sockaddrToAny(sa unix.Sockaddr) (*unix.RawSockaddrAny, Socklen, error) {
 	if sa == nil {
 		return nil, 0, syscall.EINVAL
 	}
+	rsa := new(unix.RawSockaddrAny)
 
 	switch sa := sa.(type) {
 	case *unix.SockaddrInet4:
 		if sa.Port < 0 || sa.Port > 0xFFFF {
 			return nil, 0, syscall.EINVAL
 		}
-		var raw unix.RawSockaddrInet4
+		raw := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
 		raw.Family = unix.AF_INET
 		p := (*[2]byte)(unsafe.Pointer(&raw.Port))
 		p[0] = byte(sa.Port >> 8)
 		p[1] = byte(sa.Port)
-		for i := 0; i < len(sa.Addr); i++ {
-			raw.Addr[i] = sa.Addr[i]
-		}
-		return (*unix.RawSockaddrAny)(unsafe.Pointer(&raw)), unix.SizeofSockaddrInet4, nil
+		raw.Addr = sa.Addr
+		return rsa, unix.SizeofSockaddrInet4, nil
 
 	case *unix.SockaddrInet6:
 		if sa.Port < 0 || sa.Port > 0xFFFF {
 			return nil, 0, syscall.EINVAL
 		}
-		var raw unix.RawSockaddrInet6
+		raw := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
 		raw.Family = unix.AF_INET6
 		p := (*[2]byte)(unsafe.Pointer(&raw.Port))
 		p[0] = byte(sa.Port >> 8)
 		p[1] = byte(sa.Port)
 		raw.Scope_id = sa.ZoneId
-		for i := 0; i < len(sa.Addr); i++ {
-			raw.Addr[i] = sa.Addr[i]
-		}
-		return (*unix.RawSockaddrAny)(unsafe.Pointer(&raw)), unix.SizeofSockaddrInet6, nil
+		raw.Addr = sa.Addr
+		return rsa, unix.SizeofSockaddrInet6, nil
 
 	case *unix.SockaddrUnix:
 		name := sa.Name
 		n := len(name)
-		var raw unix.RawSockaddrUnix
-		if n >= len(raw.Path) {
+		raw := (*unix.RawSockaddrUnix)(unsafe.Pointer(rsa))
+		if n == 0 || n >= len(raw.Path) {
 			return nil, 0, syscall.EINVAL
 		}
 		raw.Family = unix.AF_UNIX
@@ -75,32 +75,27 @@ func sockaddrToAny(sa unix.Sockaddr) (*unix.RawSockaddrAny, Socklen, error) {
 			raw.Path[i] = int8(name[i])
 		}
 		// length is family (uint16), name, NUL.
-		sl := Socklen(2)
-		if n > 0 {
-			sl += Socklen(n) + 1
-		}
+		sl := Socklen(2) + Socklen(n) + 1
 		if raw.Path[0] == '@' {
 			raw.Path[0] = 0
 			// Don't count trailing NUL for abstract address.
 			sl--
 		}
-		return (*unix.RawSockaddrAny)(unsafe.Pointer(&raw)), sl, nil
+		return rsa, sl, nil
 
 	case *unix.SockaddrLinklayer:
 		if sa.Ifindex < 0 || sa.Ifindex > 0x7fffffff {
 			return nil, 0, syscall.EINVAL
 		}
-		var raw unix.RawSockaddrLinklayer
+		raw := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(rsa))
 		raw.Family = unix.AF_PACKET
 		raw.Protocol = sa.Protocol
 		raw.Ifindex = int32(sa.Ifindex)
 		raw.Hatype = sa.Hatype
 		raw.Pkttype = sa.Pkttype
 		raw.Halen = sa.Halen
-		for i := 0; i < len(sa.Addr); i++ {
-			raw.Addr[i] = sa.Addr[i]
-		}
-		return (*unix.RawSockaddrAny)(unsafe.Pointer(&raw)), unix.SizeofSockaddrLinklayer, nil
+		raw.Addr = sa.Addr
+		return rsa, unix.SizeofSockaddrLinklayer, nil
 	}
 	return nil, 0, syscall.EAFNOSUPPORT
 }